@@ -23,23 +23,83 @@ api_prefix = "/api"
 [auth]
 token_length = 32
 token_header = "X-Auth-Token"
-session_duration = "1h"
+session_idle_timeout = "1h"
+session_lifetime = "24h"
+session_store = "sql"
+
+[character]
+initial_power = 0
+initial_actions = 10
+
+[visibility]
+sense_hops = 1
+
+[turn]
+actions_per_turn = 10
+recruit_trust = 10
 
 [loglevel]
 default = "WARN"
 
+[markov]
+min_name_length = 0
+max_name_length = 0
+blacklist_file = ""
+reject_corpus_words = false
+max_tries = 1000
+capitalization = "keep"
+backoff_min_count = 0
+
+[markov.corpora]
 
 [universe]
 radius = 1000
 min_place_dist = 80
 max_way_length = 150
 markov_prefix_length = 3
+markov_model = ""
+corpus = ""
+seed = 0
+link_strategy = "random"
+extra_edge_fraction = 0.1
+
+[universe.energy]
+mean = 10
+stddev = 4
+region_mean = 20
+region_stddev = 6
+core_bonus = 10
+hub_count = 3
+hub_multiplier = 4
+
+[universe.anomalies]
+cost_fraction = 0
+max_cost_multiplier = 3
+oneway_fraction = 0
+jump_count = 0
+jump_min_length = 500
+jump_cost_multiplier = 0.5
+
+[universe.validation]
+min_places = 0
+min_degree = 0
+max_diameter = 0
+max_dead_ends = 0
+max_articulation_points = 0
 
 [universe.region]
 count = 5
 radius = 120
 min_place_dist = 20
 max_way_length = 40
+corpora = []
+
+[universe.expansion]
+count = 1
+radius = 120
+min_place_dist = 20
+max_way_length = 40
+corpora = []
 `
 
 var (
@@ -61,20 +121,28 @@ func toString(v interface{}) string {
 		return str.String()
 	} else if i, ok := v.(int64); ok {
 		return strconv.FormatInt(i, 10)
+	} else if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	} else if b, ok := v.(bool); ok {
+		return strconv.FormatBool(b)
 	} else if v == nil {
 		return ""
 	}
 	panic("unexpected toml value type")
 }
 
-// Get returns a config item value as a string
-func Get(key string) string {
+func lookup(key string) interface{} {
 	treeLock.RLock()
 	defer treeLock.RUnlock()
 	if ok := tree.Has(key); !ok {
-		return toString(defaultTree.Get(key))
+		return defaultTree.Get(key)
 	}
-	return toString(tree.Get(key))
+	return tree.Get(key)
+}
+
+// Get returns a config item value as a string
+func Get(key string) string {
+	return toString(lookup(key))
 }
 
 // GetInt returns a config item value as an int
@@ -86,6 +154,60 @@ func GetInt(key string) int {
 	return i
 }
 
+// GetFloat returns a config item value as a float64
+func GetFloat(key string) float64 {
+	f, err := strconv.ParseFloat(Get(key), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// GetBool returns a config item value as a bool
+func GetBool(key string) bool {
+	b, err := strconv.ParseBool(Get(key))
+	if err != nil {
+		return false
+	}
+	return b
+}
+
+// GetStrings returns a config item value as a list of strings; a single value
+// is a list of one string.
+func GetStrings(key string) []string {
+	strs := make([]string, 0)
+	switch v := lookup(key).(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			strs = append(strs, toString(item))
+		}
+	default:
+		strs = append(strs, toString(v))
+	}
+	return strs
+}
+
+// GetStringMap returns a config table as a map of strings; keys of nested
+// tables are joined with dots.
+func GetStringMap(key string) map[string]string {
+	m := make(map[string]string)
+	if t, ok := lookup(key).(*toml.Tree); ok {
+		flattenMap(m, "", t.ToMap())
+	}
+	return m
+}
+
+func flattenMap(dst map[string]string, prefix string, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			flattenMap(dst, prefix+k+".", sub)
+		} else {
+			dst[prefix+k] = toString(v)
+		}
+	}
+}
+
 // LoadFile loads a TOML configuration file
 func LoadFile(path string) error {
 	if defaultTree == nil {
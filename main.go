@@ -7,6 +7,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/morluque/moenawark/config"
@@ -18,9 +19,14 @@ import (
 	"github.com/morluque/moenawark/server"
 	"github.com/morluque/moenawark/server/session"
 	"github.com/morluque/moenawark/sqlstore"
+	"github.com/morluque/moenawark/turn"
 	"github.com/morluque/moenawark/universe"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -61,6 +67,7 @@ func reloadConfig(path string) {
 	mwkerr.ReloadConfig()
 	password.ReloadConfig()
 	sqlstore.ReloadConfig()
+	turn.ReloadConfig()
 	universe.ReloadConfig()
 }
 
@@ -72,6 +79,12 @@ func main() {
 
 	opts := flag.NewFlagSet("moenawark", flag.PanicOnError)
 	var configPath = opts.String("cfg", "moenawark.toml", "path to TOML config file")
+	var inPath = opts.String("i", "", "path to input file")
+	var outPath = opts.String("o", "", "path to output file")
+	var format = opts.String("format", "", "map format (svg, png or dot), export format (json or geojson) or stats format (text or json); guessed from the output file extension by default")
+	var scale = opts.Float64("scale", 1, "map scale, in pixels per universe unit")
+	var characterName = opts.String("character", "", "name of a character whose knowledge of the universe is highlighted on the map")
+	var seed = opts.Int64("seed", 0, "seed of the random generator used to generate or expand the universe; overrides universe.seed")
 	opts.Parse(os.Args[2:])
 	log.Infof("config path: %s\n", *configPath)
 
@@ -84,7 +97,23 @@ func main() {
 	case "initdb":
 		initDB()
 	case "inituniverse":
-		initUniverse()
+		initUniverse(*seed)
+	case "expand":
+		expandUniverse(*seed)
+	case "trainmarkov":
+		trainMarkov(*inPath, *outPath)
+	case "blendmarkov":
+		blendMarkov(*inPath, *outPath)
+	case "resolveturn":
+		resolveTurn()
+	case "render":
+		renderMap(*outPath, *format, *scale, *characterName)
+	case "stats":
+		universeStats(*outPath, *format)
+	case "export":
+		exportUniverse(*outPath, *format)
+	case "import":
+		importUniverse(*inPath)
 	case "server":
 		server.ServeHTTP()
 		log.Infof("One day, a server will be started here. But not today.")
@@ -116,7 +145,106 @@ func readAdminUser() (*model.User, error) {
 	return u, nil
 }
 
-func initUniverse() {
+/*
+loadMarkovModel returns a generator of names following the rules set in
+config, from the name corpus or the name model file set in config, or from a
+word list read from standard input if there is none.
+*/
+func loadMarkovModel() *markov.NameGenerator {
+	var m *markov.Chains
+	var err error
+	if corpus := config.Get("universe.corpus"); len(corpus) > 0 {
+		m, err = markov.Corpus(corpus)
+	} else if path := config.Get("universe.markov_model"); len(path) > 0 {
+		m, err = markov.LoadFile(path)
+	} else {
+		m = loadWordList(os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules, err := markov.ConfiguredRules()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := markov.NewNameGenerator(m, rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return g
+}
+
+func trainMarkov(inPath, outPath string) {
+	if len(inPath) == 0 {
+		log.Fatal("Missing word list file (-i)")
+	}
+	if len(outPath) == 0 {
+		log.Fatal("Missing model file (-o)")
+	}
+	in, err := os.Open(inPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	m := loadWordList(in)
+	if err := m.SaveFile(outPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Name model trained from %s saved to %s", inPath, outPath)
+}
+
+// loadWordList analyzes a word list into fixed-order chains, or into
+// variable-order chains if a minimum count to back off is set in config.
+func loadWordList(r io.Reader) *markov.Chains {
+	prefixLen := config.GetInt("universe.markov_prefix_length")
+	if minCount := config.GetFloat("markov.backoff_min_count"); minCount > 0 {
+		return markov.LoadBackoff(r, prefixLen, minCount)
+	}
+	return markov.Load(r, prefixLen)
+}
+
+/*
+blendMarkov saves to a model file the blend of model files given as a comma
+separated list of "path=weight" items, like "elven.mwkm=2,dwarven.mwkm=1"; the
+weight defaults to 1.
+*/
+func blendMarkov(inPaths, outPath string) {
+	if len(inPaths) == 0 {
+		log.Fatal("Missing model files to blend (-i)")
+	}
+	if len(outPath) == 0 {
+		log.Fatal("Missing model file (-o)")
+	}
+	chains := make([]*markov.Chains, 0)
+	weights := make([]float64, 0)
+	for _, item := range strings.Split(inPaths, ",") {
+		path, weight := item, 1.0
+		if i := strings.LastIndex(item, "="); i >= 0 {
+			w, err := strconv.ParseFloat(item[i+1:], 64)
+			if err != nil {
+				log.Fatalf("Bad blend weight in %q", item)
+			}
+			path, weight = item[:i], w
+		}
+		m, err := markov.LoadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		chains = append(chains, m)
+		weights = append(weights, weight)
+	}
+	m, err := markov.Blend(chains, weights)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := m.SaveFile(outPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Name model blended from %s saved to %s", inPaths, outPath)
+}
+
+func initUniverse(seed int64) {
 	db, err := sqlstore.Open(config.Get("db_path"))
 	if err != nil {
 		log.Fatal(err)
@@ -127,28 +255,303 @@ func initUniverse() {
 		Radius:       float64(config.GetInt("universe.radius")),
 		MinPlaceDist: float64(config.GetInt("universe.min_place_dist")),
 		MaxWayLength: float64(config.GetInt("universe.max_way_length")),
-		MarkovGen:    markov.Load(os.Stdin, config.GetInt("universe.markov_prefix_length")),
+		MarkovGen:    loadMarkovModel(),
+		Seed:         universeSeed(seed),
 		RegionConfig: universe.RegionConfig{
 			Count:        config.GetInt("universe.region.count"),
 			Radius:       float64(config.GetInt("universe.region.radius")),
 			MinPlaceDist: float64(config.GetInt("universe.region.min_place_dist")),
 			MaxWayLength: float64(config.GetInt("universe.region.max_way_length")),
+			Corpora:      config.GetStrings("universe.region.corpora"),
+		},
+		LinkStrategy:      config.Get("universe.link_strategy"),
+		ExtraEdgeFraction: config.GetFloat("universe.extra_edge_fraction"),
+		Energy: universe.EnergyConfig{
+			Mean:          config.GetFloat("universe.energy.mean"),
+			StdDev:        config.GetFloat("universe.energy.stddev"),
+			RegionMean:    config.GetFloat("universe.energy.region_mean"),
+			RegionStdDev:  config.GetFloat("universe.energy.region_stddev"),
+			CoreBonus:     config.GetFloat("universe.energy.core_bonus"),
+			HubCount:      config.GetInt("universe.energy.hub_count"),
+			HubMultiplier: config.GetFloat("universe.energy.hub_multiplier"),
+		},
+		Anomalies: universe.AnomalyConfig{
+			CostFraction:       config.GetFloat("universe.anomalies.cost_fraction"),
+			MaxCostMultiplier:  config.GetFloat("universe.anomalies.max_cost_multiplier"),
+			OneWayFraction:     config.GetFloat("universe.anomalies.oneway_fraction"),
+			JumpCount:          config.GetInt("universe.anomalies.jump_count"),
+			JumpMinLength:      config.GetFloat("universe.anomalies.jump_min_length"),
+			JumpCostMultiplier: config.GetFloat("universe.anomalies.jump_cost_multiplier"),
 		},
 	}
 	tx, err := db.Begin()
 	if err != nil {
 		log.Fatal(err)
 	}
-	u := universe.Generate(cfg, tx)
+	u, err := universe.Generate(cfg, tx)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	stats := u.Stats()
+	stats.WriteText(os.Stdout)
+	if err := checkStats(stats); err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
 	err = tx.Commit()
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := u.WriteDotFile("tmp.gv"); err != nil {
+	log.Infof("Universe of %d places and %d wormholes generated", len(u.Places), len(u.Wormholes))
+}
+
+func expandUniverse(seed int64) {
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rc := universe.RegionConfig{
+		Count:        config.GetInt("universe.expansion.count"),
+		Radius:       float64(config.GetInt("universe.expansion.radius")),
+		MinPlaceDist: float64(config.GetInt("universe.expansion.min_place_dist")),
+		MaxWayLength: float64(config.GetInt("universe.expansion.max_way_length")),
+		Corpora:      config.GetStrings("universe.expansion.corpora"),
+	}
+	markovGen := loadMarkovModel()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	u, err := universe.Expand(tx, rc, markovGen, universeSeed(seed))
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	stats := u.Stats()
+	stats.WriteText(os.Stdout)
+	if err := checkStats(stats); err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Universe expanded to %d places and %d wormholes", len(u.Places), len(u.Wormholes))
+}
+
+// universeSeed returns the seed given on the command line, or else the one of
+// the configuration; zero lets the universe package pick one.
+func universeSeed(flagSeed int64) int64 {
+	if flagSeed != 0 {
+		return flagSeed
+	}
+	return int64(config.GetInt("universe.seed"))
+}
+
+func renderMap(outPath, format string, scale float64, characterName string) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(filepath.Ext(outPath), ".")
+	}
+	if len(format) == 0 {
+		format = "svg"
+	}
+	if len(outPath) == 0 {
+		outPath = "universe." + format
+	}
+	var write func(*universe.Universe, io.Writer, universe.RenderOptions) error
+	switch format {
+	case "svg":
+		write = (*universe.Universe).WriteSVG
+	case "png":
+		write = (*universe.Universe).WritePNG
+	case "dot", "gv":
+		write = (*universe.Universe).WriteDot
+	default:
+		log.Fatalf("Unknown map format %s", format)
+	}
+
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	u, err := universe.Load(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := universe.RenderOptions{Scale: scale}
+	if len(characterName) > 0 {
+		c, err := model.LoadCharacter(tx, characterName)
+		if err != nil {
+			log.Fatalf("Can't load character %s: %s", characterName, err.Error())
+		}
+		if opts.Highlight, err = model.LoadVisibility(tx, c.ID); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := u.CheckMapSize(opts); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	if err := write(u, out, opts); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Map written to %s", outPath)
+}
+
+// checkStats returns an error listing the validation thresholds the universe
+// violates, if any.
+func checkStats(stats *universe.Stats) error {
+	violations := stats.Validate(universe.ValidationConfig{
+		MinPlaces:             config.GetInt("universe.validation.min_places"),
+		MinDegree:             config.GetInt("universe.validation.min_degree"),
+		MaxDiameter:           config.GetInt("universe.validation.max_diameter"),
+		MaxDeadEnds:           config.GetInt("universe.validation.max_dead_ends"),
+		MaxArticulationPoints: config.GetInt("universe.validation.max_articulation_points"),
+	})
+	if len(violations) > 0 {
+		return fmt.Errorf("Universe fails validation: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+func universeStats(outPath, format string) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(filepath.Ext(outPath), ".")
+	}
+	if format != "json" {
+		format = "text"
+	}
+
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	u, err := universe.Load(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats := u.Stats()
+	out := os.Stdout
+	if len(outPath) > 0 {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(stats)
+	} else {
+		err = stats.WriteText(out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkStats(stats); err != nil {
 		log.Fatal(err)
 	}
 }
 
+func exportUniverse(outPath, format string) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(filepath.Ext(outPath), ".")
+	}
+	if len(format) == 0 {
+		format = "json"
+	}
+	if len(outPath) == 0 {
+		outPath = "universe." + format
+	}
+	var write func(*universe.Universe, io.Writer) error
+	switch format {
+	case "json":
+		write = (*universe.Universe).WriteJSON
+	case "geojson":
+		write = (*universe.Universe).WriteGeoJSON
+	default:
+		log.Fatalf("Unknown export format %s", format)
+	}
+
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	u, err := universe.Load(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	if err := write(u, out); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Universe exported to %s", outPath)
+}
+
+func importUniverse(inPath string) {
+	if len(inPath) == 0 {
+		log.Fatal("Missing input file (-i)")
+	}
+	in, err := os.Open(inPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	u, err := universe.Import(tx, in)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Universe of %d places and %d wormholes imported from %s", len(u.Places), len(u.Wormholes), inPath)
+}
+
 func initDB() {
 	db, err := sqlstore.Open(config.Get("db_path"))
 	if err != nil {
@@ -173,3 +576,25 @@ func initDB() {
 	}
 	log.Infof("Created admin user %s", admin.Login)
 }
+
+func resolveTurn() {
+	db, err := sqlstore.Open(config.Get("db_path"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	next, err := turn.Resolve(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Turn %d is now open", next.ID)
+}
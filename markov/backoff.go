@@ -0,0 +1,203 @@
+package markov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// startOfWord pads the beginning of words of variable-order chains, so that
+// prefixes also describe how words start.
+const startOfWord rune = 1
+
+/*
+LoadBackoff loads and analyzes a list of words (one per line) from a
+io.Reader, like Load, but into variable-order chains.
+
+Probabilities are recorded for prefixes of 1 to maxPrefixLen characters. When
+generating, the next character is drawn after the longest prefix that was
+observed at least minCount times in the word list, backing off to shorter
+prefixes otherwise. Small word lists thus yield words that are neither
+gibberish nor copies of the list.
+*/
+func LoadBackoff(r io.Reader, maxPrefixLen int, minCount float64) *Chains {
+	fscanner := bufio.NewScanner(r)
+	words := make([]string, 0)
+	for fscanner.Scan() {
+		words = append(words, fscanner.Text())
+	}
+	if minCount < 1 {
+		minCount = 1
+	}
+	m := analyzeBackoff(maxPrefixLen, minCount, words)
+	log.Debugf("loaded %d words", len(words))
+	m.normalize()
+
+	return m
+}
+
+func analyzeBackoff(prefixLen int, minCount float64, words []string) *Chains {
+	m := newMarkovChains(prefixLen)
+	m.minCount = minCount
+	for n, w := range words {
+		if !utf8.ValidString(w) {
+			log.Fatalf("invalid UTF8 string %q at line %d", w, n+1)
+			break
+		}
+		if len(w) == 0 {
+			continue
+		}
+		m.words[strings.ToLower(w)] = true
+		runes := append(startPadding(prefixLen), getRunes(w)...)
+		for i := prefixLen; i <= len(runes); i++ {
+			suffix := endOfWord
+			if i < len(runes) {
+				suffix = runes[i]
+			}
+			for k := 1; k <= prefixLen; k++ {
+				prefix := runesToString(runes[i-k : i])
+				m.add(prefix, suffix)
+				m.counts[prefix]++
+			}
+		}
+	}
+
+	return m
+}
+
+func startPadding(n int) []rune {
+	runes := make([]rune, n)
+	for i := range runes {
+		runes[i] = startOfWord
+	}
+	return runes
+}
+
+func (m *Chains) generateBackoff(rnd *rand.Rand) string {
+	wordRunes := startPadding(m.prefixLen)
+	for {
+		ru := m.next(m.backoffPrefix(wordRunes), rnd.Float64())
+		if ru == endOfWord {
+			break
+		}
+		wordRunes = append(wordRunes, ru)
+	}
+	return runesToString(wordRunes[m.prefixLen:])
+}
+
+// backoffPrefix returns the longest prefix ending wordRunes that was observed
+// often enough, or the last character.
+func (m *Chains) backoffPrefix(wordRunes []rune) string {
+	for k := m.prefixLen; k > 1; k-- {
+		prefix := runesToString(wordRunes[len(wordRunes)-k:])
+		if m.counts[prefix] >= m.minCount {
+			return prefix
+		}
+	}
+	return runesToString(wordRunes[len(wordRunes)-1:])
+}
+
+/*
+Blend interpolates chains with weights, to create hybrid naming cultures from
+several word lists.
+
+After each prefix, the probability of a character is the weighted mean of its
+probabilities in the chains that know the prefix; words start like those of
+each chains in proportion to its weight. All chains must have the same prefix
+length and be either fixed-order or variable-order; variable-order chains
+back off using the highest minimum count among them.
+*/
+func Blend(chains []*Chains, weights []float64) (*Chains, error) {
+	if len(chains) == 0 || len(chains) != len(weights) {
+		return nil, fmt.Errorf("need as many weights as Markov chains to blend")
+	}
+	var total float64
+	for i, c := range chains {
+		if weights[i] < 0 || math.IsNaN(weights[i]) {
+			return nil, fmt.Errorf("bad blend weight %g", weights[i])
+		}
+		if c.prefixLen != chains[0].prefixLen {
+			return nil, fmt.Errorf("can't blend Markov chains of prefix lengths %d and %d", chains[0].prefixLen, c.prefixLen)
+		}
+		if (c.minCount > 0) != (chains[0].minCount > 0) {
+			return nil, fmt.Errorf("can't blend fixed-order and variable-order Markov chains")
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("blend weights sum to zero")
+	}
+
+	m := newMarkovChains(chains[0].prefixLen)
+	prefixWeights := make(map[string]float64)
+	for i, c := range chains {
+		if weights[i] == 0 {
+			continue
+		}
+		m.minCount = math.Max(m.minCount, c.minCount)
+		for prefix := range c.digraphs {
+			prefixWeights[prefix] += weights[i]
+		}
+		for prefix, n := range c.counts {
+			m.counts[prefix] += n
+		}
+		for w := range c.words {
+			m.words[w] = true
+		}
+	}
+	for i, c := range chains {
+		if weights[i] == 0 {
+			continue
+		}
+		for prefix, d := range c.digraphs {
+			b, ok := m.digraphs[prefix]
+			if !ok {
+				b = make(digraph)
+				m.digraphs[prefix] = b
+			}
+			for r, p := range d {
+				b[r] += p * weights[i] / prefixWeights[prefix]
+			}
+		}
+	}
+	for prefix, d := range m.digraphs {
+		runes := make([]rune, 0, len(d))
+		for r := range d {
+			runes = append(runes, r)
+		}
+		sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+		m.suffixes[prefix] = runes
+	}
+	if m.minCount <= 0 {
+		m.blendStarts(chains, weights, total)
+	}
+
+	return m, nil
+}
+
+// blendStarts sets the distinct starts of the blend of chains, sorted, with
+// weights so that they are drawn in proportion to the weight of each chains.
+func (m *Chains) blendStarts(chains []*Chains, weights []float64, total float64) {
+	share := make(map[string]float64)
+	for i, c := range chains {
+		if weights[i] == 0 || len(c.starts) == 0 {
+			continue
+		}
+		for s, p := range c.startShares() {
+			share[s] += p * weights[i] / total
+		}
+	}
+	starts := make([]string, 0, len(share))
+	for s := range share {
+		starts = append(starts, s)
+	}
+	sort.Strings(starts)
+	for _, s := range starts {
+		m.addWeightedStart(s, share[s])
+	}
+}
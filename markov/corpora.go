@@ -0,0 +1,72 @@
+package markov
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	corpora     = make(map[string]*Chains)
+	corporaLock = sync.RWMutex{}
+)
+
+/*
+Register makes chains available under a corpus name, like "places.core" or
+"ships", replacing chains previously registered under that name.
+
+Corpora listed in the [markov.corpora] table of config, as names and paths to
+model files, are registered when config is reloaded.
+*/
+func Register(name string, m *Chains) {
+	corporaLock.Lock()
+	defer corporaLock.Unlock()
+	corpora[name] = m
+}
+
+// Corpus returns the chains registered under a name.
+func Corpus(name string) (*Chains, error) {
+	corporaLock.RLock()
+	defer corporaLock.RUnlock()
+	m, ok := corpora[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name corpus %q", name)
+	}
+	return m, nil
+}
+
+// CorpusNames returns the sorted names of registered corpora.
+func CorpusNames() []string {
+	corporaLock.RLock()
+	defer corporaLock.RUnlock()
+	names := make([]string, 0, len(corpora))
+	for name := range corpora {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCorpusNameGenerator creates a generator of names following rules, from
+// the chains registered under a corpus name.
+func NewCorpusNameGenerator(name string, rules NameRules) (*NameGenerator, error) {
+	m, err := Corpus(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewNameGenerator(m, rules)
+}
+
+// loadCorpora registers the chains of model files, by corpus name; corpora
+// whose file can't be loaded keep their previous chains, if any.
+func loadCorpora(paths map[string]string) {
+	for name, path := range paths {
+		m, err := LoadFile(path)
+		if err != nil {
+			log.Errorf("can't load name corpus %s: %s", name, err.Error())
+			continue
+		}
+		Register(name, m)
+		log.Debugf("registered name corpus %s from %s", name, path)
+	}
+}
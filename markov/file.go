@@ -0,0 +1,280 @@
+package markov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatVersion is the version of the model file format written by Encode.
+const FormatVersion = 1
+
+const formatMagic = "mwkmarkov"
+
+/*
+Encode writes the chains in the model file format, so that they can be
+decoded later instead of analyzing a word list again.
+
+The format is line-oriented text: a "mwkmarkov <version>" header, then a
+"prefix <length>" line, "start <prefix> <count>" lines, or "wstart <prefix>
+<weight>" lines for starts that are not equally likely, one "next <prefix>
+<suffix> <probability>..." line per prefix and one "word <word>" line per
+training word. Variable-order chains have a "backoff <minimum count>" line
+after the prefix length and one "count <prefix> <observations>" line per
+prefix instead of starts. Fields are separated by tabs and strings are quoted
+as Go strings; the end of word is the empty suffix.
+*/
+func (m *Chains) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %d\n", formatMagic, FormatVersion)
+	fmt.Fprintf(bw, "prefix\t%d\n", m.prefixLen)
+	if m.minCount > 0 {
+		fmt.Fprintf(bw, "backoff\t%s\n", strconv.FormatFloat(m.minCount, 'g', -1, 64))
+	}
+
+	var prefixes []string
+	if m.startWeights != nil {
+		for i, s := range m.starts {
+			fmt.Fprintf(bw, "wstart\t%s\t%s\n", strconv.Quote(s), strconv.FormatFloat(m.startWeights[i], 'g', -1, 64))
+		}
+	} else {
+		starts := make(map[string]float64)
+		for _, s := range m.starts {
+			starts[s]++
+		}
+		prefixes = sortedKeys(starts)
+		for _, s := range prefixes {
+			fmt.Fprintf(bw, "start\t%s\t%d\n", strconv.Quote(s), int(starts[s]))
+		}
+	}
+
+	prefixes = make([]string, 0, len(m.digraphs))
+	for prefix := range m.digraphs {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(bw, "next\t%s", strconv.Quote(prefix))
+		for _, r := range m.suffixes[prefix] {
+			suffix := ""
+			if r != endOfWord {
+				suffix = string(r)
+			}
+			fmt.Fprintf(bw, "\t%s\t%s", strconv.Quote(suffix), strconv.FormatFloat(m.digraphs[prefix][r], 'g', -1, 64))
+		}
+		fmt.Fprint(bw, "\n")
+	}
+	if m.minCount > 0 {
+		for _, prefix := range prefixes {
+			fmt.Fprintf(bw, "count\t%s\t%s\n", strconv.Quote(prefix), strconv.FormatFloat(m.counts[prefix], 'g', -1, 64))
+		}
+	}
+
+	words := make([]string, 0, len(m.words))
+	for w := range m.words {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	for _, w := range words {
+		fmt.Fprintf(bw, "word\t%s\n", strconv.Quote(w))
+	}
+	return bw.Flush()
+}
+
+/*
+Decode reads chains in the model file format written by Encode. Once decoded,
+they are ready to generate random words.
+
+Starts are grouped and sorted by Encode, so a given seed doesn't yield the
+same words as the chains before they were encoded.
+*/
+func Decode(r io.Reader) (*Chains, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty Markov model")
+	}
+	var magic string
+	var version int
+	if _, err := fmt.Sscanf(scanner.Text(), "%s %d", &magic, &version); err != nil || magic != formatMagic {
+		return nil, fmt.Errorf("not a Markov model, header is %q", scanner.Text())
+	}
+	if version != FormatVersion {
+		return nil, fmt.Errorf("unsupported Markov model version %d", version)
+	}
+
+	m := newMarkovChains(0)
+	for n := 2; scanner.Scan(); n++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if err := m.decodeLine(fields); err != nil {
+			return nil, fmt.Errorf("line %d of Markov model: %s", n, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.prefixLen <= 0 || (m.minCount <= 0 && len(m.starts) == 0) {
+		return nil, fmt.Errorf("Markov model has no prefix length or no start")
+	}
+	for prefix := range m.digraphs {
+		sort.Slice(m.suffixes[prefix], func(i, j int) bool { return m.suffixes[prefix][i] < m.suffixes[prefix][j] })
+	}
+	log.Debugf("decoded Markov model of %d starts and %d prefixes", len(m.starts), len(m.digraphs))
+	return m, nil
+}
+
+func (m *Chains) decodeLine(fields []string) error {
+	switch {
+	case fields[0] == "prefix" && len(fields) == 2:
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("bad prefix length %q", fields[1])
+		}
+		m.prefixLen = n
+	case fields[0] == "backoff" && len(fields) == 2:
+		if m.prefixLen <= 0 {
+			return fmt.Errorf("prefix length must come first")
+		}
+		n, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("bad minimum count %q", fields[1])
+		}
+		m.minCount = n
+	case fields[0] == "count" && len(fields) == 3:
+		prefix, err := m.decodePrefix(fields[1])
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad observation count %q", fields[2])
+		}
+		m.counts[prefix] = n
+	case fields[0] == "start" && len(fields) == 3:
+		prefix, err := m.decodePrefix(fields[1])
+		if err != nil {
+			return err
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count <= 0 {
+			return fmt.Errorf("bad start count %q", fields[2])
+		}
+		if m.startWeights != nil {
+			return fmt.Errorf("start and wstart records can't be mixed")
+		}
+		for i := 0; i < count; i++ {
+			m.starts = append(m.starts, prefix)
+		}
+	case fields[0] == "wstart" && len(fields) == 3:
+		prefix, err := m.decodePrefix(fields[1])
+		if err != nil {
+			return err
+		}
+		weight, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || weight <= 0 || math.IsInf(weight, 0) {
+			return fmt.Errorf("bad start weight %q", fields[2])
+		}
+		if len(m.starts) > 0 && m.startWeights == nil {
+			return fmt.Errorf("start and wstart records can't be mixed")
+		}
+		m.addWeightedStart(prefix, weight)
+	case fields[0] == "next" && len(fields) >= 4 && len(fields)%2 == 0:
+		prefix, err := m.decodePrefix(fields[1])
+		if err != nil {
+			return err
+		}
+		if _, found := m.digraphs[prefix]; found {
+			return fmt.Errorf("duplicate prefix %s", fields[1])
+		}
+		d := make(digraph)
+		for i := 2; i < len(fields); i += 2 {
+			suffix, err := strconv.Unquote(fields[i])
+			if err != nil || utf8.RuneCountInString(suffix) > 1 {
+				return fmt.Errorf("bad suffix %s", fields[i])
+			}
+			r := endOfWord
+			if len(suffix) > 0 {
+				r, _ = utf8.DecodeRuneInString(suffix)
+			}
+			p, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil || p < 0 || p > 1 {
+				return fmt.Errorf("bad probability %q", fields[i+1])
+			}
+			d[r] = p
+			m.suffixes[prefix] = append(m.suffixes[prefix], r)
+		}
+		m.digraphs[prefix] = d
+	case fields[0] == "word" && len(fields) == 2:
+		word, err := strconv.Unquote(fields[1])
+		if err != nil || len(word) == 0 {
+			return fmt.Errorf("bad word %s", fields[1])
+		}
+		m.words[word] = true
+	default:
+		return fmt.Errorf("unknown record %q", fields[0])
+	}
+	return nil
+}
+
+func (m *Chains) decodePrefix(quoted string) (string, error) {
+	prefix, err := strconv.Unquote(quoted)
+	if err != nil || !utf8.ValidString(prefix) {
+		return "", fmt.Errorf("bad prefix %s", quoted)
+	}
+	if m.prefixLen <= 0 {
+		return "", fmt.Errorf("prefix length must come first")
+	}
+	n := utf8.RuneCountInString(prefix)
+	if m.minCount > 0 && (n < 1 || n > m.prefixLen) {
+		return "", fmt.Errorf("prefix %s is not 1 to %d characters long", quoted, m.prefixLen)
+	}
+	if m.minCount <= 0 && n != m.prefixLen {
+		return "", fmt.Errorf("prefix %s is not %d characters long", quoted, m.prefixLen)
+	}
+	return prefix, nil
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LoadFile decodes chains from a model file.
+func LoadFile(path string) (*Chains, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return m, nil
+}
+
+// SaveFile encodes chains to a model file.
+func (m *Chains) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/morluque/moenawark/loglevel"
 	"io"
 	"math/rand"
+	"sort"
+	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -18,8 +21,24 @@ import (
 // words.
 type Chains struct {
 	prefixLen int
-	starts    []string
-	digraphs  map[string]digraph
+	// minCount is positive for variable-order chains, see LoadBackoff.
+	minCount float64
+	starts   []string
+	// startWeights holds the weights of starts, for chains whose starts are
+	// not equally likely, like blends; it is nil otherwise. startCumulative
+	// holds their running sums, to draw starts by binary search.
+	startWeights    []float64
+	startCumulative []float64
+	digraphs        map[string]digraph
+	// counts holds the number of observations of each prefix of
+	// variable-order chains.
+	counts map[string]float64
+	// suffixes holds the keys of each digraph in a stable order, so that
+	// generation is reproducible.
+	suffixes map[string][]rune
+	// words holds the lower-cased words the chains were trained on.
+	words map[string]bool
+	rnd   *rand.Rand
 }
 
 type digraph map[rune]float64
@@ -35,12 +54,27 @@ func init() {
 // ReloadConfig performs required actions to reload all dynamic config.
 func ReloadConfig() {
 	log.SetLevelName(config.Get("loglevel.markov"))
+	loadCorpora(config.GetStringMap("markov.corpora"))
 }
 
 func newMarkovChains(prefixLen int) *Chains {
 	starts := make([]string, 0)
 	digraphs := make(map[string]digraph)
-	return &Chains{prefixLen: prefixLen, digraphs: digraphs, starts: starts}
+	return &Chains{
+		prefixLen: prefixLen,
+		digraphs:  digraphs,
+		starts:    starts,
+		counts:    make(map[string]float64),
+		suffixes:  make(map[string][]rune),
+		words:     make(map[string]bool),
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Seed initializes the random source used to generate words; the same seed
+// always yields the same sequence of words.
+func (m *Chains) Seed(seed int64) {
+	m.rnd = rand.New(rand.NewSource(seed))
 }
 
 func (m *Chains) add(prefix string, suffix rune) {
@@ -53,7 +87,7 @@ func (m *Chains) add(prefix string, suffix rune) {
 }
 
 func (m *Chains) normalize() {
-	for _, d := range m.digraphs {
+	for prefix, d := range m.digraphs {
 		var total float64
 		runes := make([]rune, 0)
 		for r, count := range d {
@@ -63,6 +97,8 @@ func (m *Chains) normalize() {
 		for _, r := range runes {
 			d[r] = d[r] / total
 		}
+		sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+		m.suffixes[prefix] = runes
 	}
 }
 
@@ -96,6 +132,9 @@ func analyzeWords(prefixLen int, words []string) *Chains {
 			log.Fatalf("invalid UTF8 string %q at line %d", w, n+1)
 			break
 		}
+		if len(w) > 0 {
+			m.words[strings.ToLower(w)] = true
+		}
 		if len(w) <= m.prefixLen {
 			continue
 		}
@@ -131,6 +170,12 @@ func Load(r io.Reader, prefixLen int) *Chains {
 	return m
 }
 
+// InCorpus returns true if the word, whatever its case, is one of the words
+// the chains were trained on.
+func (m *Chains) InCorpus(word string) bool {
+	return m.words[strings.ToLower(word)]
+}
+
 func advancePrefix(prefix string, r rune) (rune, string) {
 	prefixRunes := getRunes(prefix)
 	prefixRunes = append(prefixRunes, r)
@@ -142,20 +187,18 @@ func advancePrefix(prefix string, r rune) (rune, string) {
 // Generate returns a random word according to the probabilities stored in
 // markov.Chains .
 func (m *Chains) Generate() string {
+	return m.generate(m.rnd)
+}
+
+func (m *Chains) generate(rnd *rand.Rand) string {
+	if m.minCount > 0 {
+		return m.generateBackoff(rnd)
+	}
 	wordRunes := make([]rune, 0)
-	prefix := m.starts[rand.Intn(len(m.starts))]
+	prefix := m.pickStart(rnd)
 	var selectedRune rune // default value is 0
 	for {
-		p := rand.Float64()
-		var n float64
-		var ru rune // default value is 0
-		for r, q := range m.digraphs[prefix] {
-			n += q
-			if p <= n {
-				ru = r
-				break
-			}
-		}
+		ru := m.next(prefix, rnd.Float64())
 		if ru == 0 {
 			// end of word
 			wordRunes = append(wordRunes, getRunes(prefix)...)
@@ -167,3 +210,57 @@ func (m *Chains) Generate() string {
 	}
 	return runesToString(wordRunes)
 }
+
+// addWeightedStart adds a start that is drawn in proportion to its weight.
+func (m *Chains) addWeightedStart(prefix string, weight float64) {
+	var sum float64
+	if n := len(m.startCumulative); n > 0 {
+		sum = m.startCumulative[n-1]
+	}
+	m.starts = append(m.starts, prefix)
+	m.startWeights = append(m.startWeights, weight)
+	m.startCumulative = append(m.startCumulative, sum+weight)
+}
+
+// pickStart returns a random start, according to start weights if any.
+func (m *Chains) pickStart(rnd *rand.Rand) string {
+	if m.startWeights == nil {
+		return m.starts[rnd.Intn(len(m.starts))]
+	}
+	p := rnd.Float64() * m.startCumulative[len(m.startCumulative)-1]
+	i := sort.Search(len(m.startCumulative), func(i int) bool { return m.startCumulative[i] > p })
+	if i == len(m.starts) {
+		i--
+	}
+	return m.starts[i]
+}
+
+// startShares returns the share of each distinct start, the shares summing
+// to one for equally likely starts.
+func (m *Chains) startShares() map[string]float64 {
+	shares := make(map[string]float64)
+	if m.startWeights == nil {
+		for _, s := range m.starts {
+			shares[s] += 1 / float64(len(m.starts))
+		}
+		return shares
+	}
+	total := m.startCumulative[len(m.startCumulative)-1]
+	for i, s := range m.starts {
+		shares[s] += m.startWeights[i] / total
+	}
+	return shares
+}
+
+// next returns the suffix of prefix at cumulated probability p, the end of
+// word if there is none.
+func (m *Chains) next(prefix string, p float64) rune {
+	var n float64
+	for _, r := range m.suffixes[prefix] {
+		n += m.digraphs[prefix][r]
+		if p <= n {
+			return r
+		}
+	}
+	return endOfWord
+}
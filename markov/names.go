@@ -0,0 +1,188 @@
+package markov
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/morluque/moenawark/config"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Capitalization rules of generated names.
+const (
+	// CapitalizeKeep leaves names as generated.
+	CapitalizeKeep = "keep"
+	// CapitalizeFirst upper-cases the first letter of names and
+	// lower-cases the others.
+	CapitalizeFirst = "first"
+	// CapitalizeWords upper-cases the first letter of each word of names,
+	// words being separated by spaces, hyphens or apostrophes, and
+	// lower-cases the others.
+	CapitalizeWords = "words"
+)
+
+/*
+NameRules constrain the names produced by a NameGenerator.
+
+Lengths are counted in characters; zero means no bound. Names matching any
+regular expression of the blacklist are rejected, as are words of the corpus
+the chains were trained on if RejectCorpusWords is set.
+*/
+type NameRules struct {
+	MinLength         int
+	MaxLength         int
+	Blacklist         []*regexp.Regexp
+	RejectCorpusWords bool
+	// MaxTries is the number of words generated to find an acceptable
+	// name before giving up.
+	MaxTries int
+	// Capitalization is one of the Capitalize* rules; it is applied before
+	// names are checked.
+	Capitalization string
+}
+
+/*
+NameGenerator generates names from Markov chains, following rules.
+
+It has its own random source, so that several generators can share chains;
+a generator must not be used by several goroutines at once.
+*/
+type NameGenerator struct {
+	chains *Chains
+	rules  NameRules
+	rnd    *rand.Rand
+}
+
+// NewNameGenerator creates a generator of names following rules, from chains.
+func NewNameGenerator(chains *Chains, rules NameRules) (*NameGenerator, error) {
+	switch rules.Capitalization {
+	case "", CapitalizeKeep, CapitalizeFirst, CapitalizeWords:
+	default:
+		return nil, fmt.Errorf("unknown capitalization rule %q", rules.Capitalization)
+	}
+	if rules.MaxLength > 0 && rules.MinLength > rules.MaxLength {
+		return nil, fmt.Errorf("minimum name length %d is greater than maximum %d", rules.MinLength, rules.MaxLength)
+	}
+	if rules.MaxTries <= 0 {
+		rules.MaxTries = 1
+	}
+	if rules.RejectCorpusWords && len(chains.words) == 0 {
+		log.Warnf("corpus words are unknown, they can't be rejected")
+	}
+	g := &NameGenerator{chains: chains, rules: rules}
+	g.Seed(time.Now().UnixNano())
+	return g, nil
+}
+
+// Rules returns the rules the generated names follow.
+func (g *NameGenerator) Rules() NameRules {
+	return g.rules
+}
+
+// Seed initializes the random source used to generate names; the same seed
+// always yields the same sequence of names.
+func (g *NameGenerator) Seed(seed int64) {
+	g.rnd = rand.New(rand.NewSource(seed))
+}
+
+/*
+Generate returns a random name that follows the rules and is not already
+taken.
+
+An error is returned if no acceptable name was found within the retry budget;
+the chains probably can't produce enough distinct names.
+*/
+func (g *NameGenerator) Generate(taken map[string]bool) (string, error) {
+	for try := 0; try < g.rules.MaxTries; try++ {
+		name := capitalize(g.chains.generate(g.rnd), g.rules.Capitalization)
+		if !taken[name] && g.acceptable(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no acceptable name found in %d tries", g.rules.MaxTries)
+}
+
+func (g *NameGenerator) acceptable(name string) bool {
+	n := utf8.RuneCountInString(name)
+	if n == 0 || n < g.rules.MinLength || (g.rules.MaxLength > 0 && n > g.rules.MaxLength) {
+		return false
+	}
+	for _, re := range g.rules.Blacklist {
+		if re.MatchString(name) {
+			log.Debugf("name %q is blacklisted by %s", name, re.String())
+			return false
+		}
+	}
+	if g.rules.RejectCorpusWords && g.chains.InCorpus(name) {
+		log.Debugf("name %q is a corpus word", name)
+		return false
+	}
+	return true
+}
+
+// ConfiguredRules returns the name rules set in the [markov] section of
+// config.
+func ConfiguredRules() (NameRules, error) {
+	rules := NameRules{
+		MinLength:         config.GetInt("markov.min_name_length"),
+		MaxLength:         config.GetInt("markov.max_name_length"),
+		RejectCorpusWords: config.GetBool("markov.reject_corpus_words"),
+		MaxTries:          config.GetInt("markov.max_tries"),
+		Capitalization:    config.Get("markov.capitalization"),
+	}
+	if path := config.Get("markov.blacklist_file"); len(path) > 0 {
+		blacklist, err := LoadBlacklist(path)
+		if err != nil {
+			return rules, err
+		}
+		rules.Blacklist = blacklist
+	}
+	return rules, nil
+}
+
+func capitalize(name, rule string) string {
+	if rule != CapitalizeFirst && rule != CapitalizeWords {
+		return name
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		if i == 0 || (rule == CapitalizeWords && strings.ContainsRune(" -'", runes[i-1])) {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+	}
+	return string(runes)
+}
+
+/*
+LoadBlacklist reads regular expressions from a file, one per line, to reject
+names; they are matched case-insensitively. Empty lines and lines starting
+with "#" are ignored.
+*/
+func LoadBlacklist(path string) ([]*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	blacklist := make([]*regexp.Regexp, 0)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, n, err.Error())
+		}
+		blacklist = append(blacklist, re)
+	}
+	return blacklist, scanner.Err()
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/morluque/moenawark/mwkerr"
 	"github.com/morluque/moenawark/sqlstore"
 )
@@ -37,10 +38,11 @@ func (c *Character) create(db *sql.Tx) error {
 
 func (c *Character) update(db *sql.Tx) error {
 	_, err := db.Exec(
-		"UPDATE characters SET name = $1, power = $2, actions = $3",
+		"UPDATE characters SET name = $1, power = $2, actions = $3 WHERE id = $4",
 		c.Name,
 		c.Power,
-		c.Actions)
+		c.Actions,
+		c.ID)
 	return err
 }
 
@@ -62,6 +64,66 @@ func (c *Character) Save(db *sql.Tx) error {
 	return nil
 }
 
+// CountEntities returns the number of entities controlled by the character.
+func (c *Character) CountEntities(db *sql.Tx) (int, error) {
+	var n int
+	row := db.QueryRow("SELECT count(*) FROM entities WHERE character_id = $1", c.ID)
+	err := row.Scan(&n)
+	return n, err
+}
+
+/*
+Delete removes the character from database.
+
+A character can only be deleted as long as it does not control any entity;
+its orders and what it knows of the universe are deleted too, and its user is
+left without character.
+*/
+func (c *Character) Delete(db *sql.Tx) error {
+	n, err := c.CountEntities(db)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Warnf("Character %s controls %d entities, can't delete now.", c.Name, n)
+		return mwkerr.New(mwkerr.CharacterInUse, "Can only delete characters without entities, but %s controls %d", c.Name, n)
+	}
+	if _, err := db.Exec("DELETE FROM orders WHERE character_id = $1", c.ID); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM place_visibility WHERE character_id = $1", c.ID); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE users SET character_id = NULL WHERE character_id = $1", c.ID); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM characters WHERE id = $1", c.ID)
+	return err
+}
+
+// ListCharacters loads a list of characters from database with pagination.
+func ListCharacters(db *sql.Tx, first, count uint) ([]Character, error) {
+	characters := make([]Character, 0, count)
+	rows, err := db.Query(`
+	    SELECT id, name, power, actions
+	      FROM characters
+	  ORDER BY id
+	     LIMIT $1 OFFSET $2`, count, first)
+	if err != nil {
+		return characters, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Character
+		if err := rows.Scan(&c.ID, &c.Name, &c.Power, &c.Actions); err != nil {
+			return characters, err
+		}
+		characters = append(characters, c)
+	}
+	return characters, rows.Err()
+}
+
 // LoadCharacter fetches a character from databse by it's name.
 func LoadCharacter(db *sql.Tx, name string) (*Character, error) {
 	var id int64
@@ -85,3 +147,30 @@ func LoadCharacterByID(db *sql.Tx, id int64) (*Character, error) {
 	}
 	return &Character{ID: id, Name: name, Power: power, Actions: actions}, nil
 }
+
+// SpendActions uses some actions of the character of an ID; it fails if the
+// character does not have enough actions left.
+func SpendActions(db *sql.Tx, characterID int64, actions uint) error {
+	result, err := db.Exec(
+		"UPDATE characters SET actions = actions - $1 WHERE id = $2 AND actions >= $1",
+		actions,
+		characterID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("Character %d does not have %d actions left", characterID, actions)
+	}
+	return nil
+}
+
+// ResetActions gives every character the same number of actions, at the
+// start of a turn.
+func ResetActions(db *sql.Tx, actions uint) error {
+	_, err := db.Exec("UPDATE characters SET actions = $1", actions)
+	return err
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UniverseGeneration records how (part of) the universe was generated, so
+// that it can be generated again.
+type UniverseGeneration struct {
+	ID         int64     `json:"id"`
+	Seed       int64     `json:"seed"`
+	ConfigJSON string    `json:"config"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NewUniverseGeneration initializes a new universe generation record.
+func NewUniverseGeneration(seed int64, configJSON string) *UniverseGeneration {
+	return &UniverseGeneration{Seed: seed, ConfigJSON: configJSON, CreatedAt: time.Now()}
+}
+
+// Save stores a universe generation record in database; records are never
+// updated.
+func (g *UniverseGeneration) Save(db *sql.Tx) error {
+	result, err := db.Exec(
+		"INSERT INTO universe_generations (seed, config_json, created_at) VALUES ($1, $2, $3)",
+		g.Seed,
+		g.ConfigJSON,
+		g.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	g.ID = id
+	return nil
+}
+
+// LoadUniverseGenerations fetches all universe generation records, oldest
+// first.
+func LoadUniverseGenerations(db *sql.Tx) ([]*UniverseGeneration, error) {
+	generations := make([]*UniverseGeneration, 0)
+	rows, err := db.Query("SELECT id, seed, config_json, created_at FROM universe_generations ORDER BY id")
+	if err != nil {
+		return generations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var createdAt int64
+		g := &UniverseGeneration{}
+		if err := rows.Scan(&g.ID, &g.Seed, &g.ConfigJSON, &createdAt); err != nil {
+			return generations, err
+		}
+		g.CreatedAt = time.Unix(createdAt, 0)
+		generations = append(generations, g)
+	}
+	return generations, rows.Err()
+}
@@ -0,0 +1,422 @@
+package model
+
+import (
+	"container/heap"
+	"database/sql"
+	"github.com/morluque/moenawark/mwkerr"
+	"sort"
+)
+
+/*
+WormholeGraph is the network of places linked by wormholes, loaded once to
+answer routing queries.
+
+Wormholes that are not one-way can be traversed both ways, so they are
+stored twice as links, once from each of their ends. Structural queries, like
+connected components or articulation points, ignore the direction of
+one-way wormholes.
+*/
+type WormholeGraph struct {
+	places map[int64]*Place
+	links  map[int64][]*Wormhole
+	ends   map[int64][]*Wormhole
+	// edges holds, for each wormhole of ends, its index in wormholes, so
+	// that parallel wormholes can be told apart.
+	edges     map[int64][]int
+	wormholes []*Wormhole
+}
+
+// Route is a way from a place to another through wormholes.
+type Route struct {
+	Places    []*Place    `json:"places"`
+	Wormholes []*Wormhole `json:"wormholes"`
+	Distance  int         `json:"distance"`
+	// Cost is the sum of the costs of the wormholes; shortest routes
+	// minimize it.
+	Cost int `json:"cost"`
+}
+
+// Hops returns the number of wormholes to traverse along the route.
+func (r *Route) Hops() int {
+	return len(r.Wormholes)
+}
+
+// Steps returns the number of movement points needed to follow the route.
+func (r *Route) Steps() int {
+	steps := 0
+	for _, w := range r.Wormholes {
+		steps += w.Steps()
+	}
+	return steps
+}
+
+// NewWormholeGraph builds the graph of the given places and wormholes.
+func NewWormholeGraph(places []*Place, wormholes []*Wormhole) *WormholeGraph {
+	g := &WormholeGraph{
+		places: make(map[int64]*Place),
+		links:  make(map[int64][]*Wormhole),
+		ends:   make(map[int64][]*Wormhole),
+		edges:  make(map[int64][]int),
+	}
+	for _, p := range places {
+		g.places[p.ID] = p
+	}
+	for _, w := range wormholes {
+		if g.Place(w.Source.ID) == nil || g.Place(w.Destination.ID) == nil {
+			continue
+		}
+		g.wormholes = append(g.wormholes, w)
+		reverse := &Wormhole{
+			ID:             w.ID,
+			Source:         w.Destination,
+			Destination:    w.Source,
+			Distance:       w.Distance,
+			Kind:           w.Kind,
+			CostMultiplier: w.CostMultiplier,
+		}
+		g.links[w.Source.ID] = append(g.links[w.Source.ID], w)
+		if w.Reversible() {
+			g.links[w.Destination.ID] = append(g.links[w.Destination.ID], reverse)
+		}
+		g.ends[w.Source.ID] = append(g.ends[w.Source.ID], w)
+		g.ends[w.Destination.ID] = append(g.ends[w.Destination.ID], reverse)
+		g.edges[w.Source.ID] = append(g.edges[w.Source.ID], len(g.wormholes)-1)
+		g.edges[w.Destination.ID] = append(g.edges[w.Destination.ID], len(g.wormholes)-1)
+	}
+	return g
+}
+
+// LoadWormholeGraph loads the whole wormhole network from database.
+func LoadWormholeGraph(db *sql.Tx) (*WormholeGraph, error) {
+	places, err := LoadAllPlaces(db)
+	if err != nil {
+		return nil, err
+	}
+	wormholes, err := LoadAllWormholes(db)
+	if err != nil {
+		return nil, err
+	}
+	return NewWormholeGraph(places, wormholes), nil
+}
+
+// LoadAllPlaces fetches every place of the universe, ordered by ID.
+func LoadAllPlaces(db *sql.Tx) ([]*Place, error) {
+	places := make([]*Place, 0)
+	rows, err := db.Query("SELECT " + placeColumns + " FROM places ORDER BY id")
+	if err != nil {
+		return places, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPlace(rows)
+		if err != nil {
+			return places, err
+		}
+		places = append(places, p)
+	}
+	return places, rows.Err()
+}
+
+// Restrict returns the graph restricted to the places a character knows
+// about.
+func (g *WormholeGraph) Restrict(v Visibility) *WormholeGraph {
+	places := make([]*Place, 0)
+	for id, p := range g.places {
+		if v.CanSee(id) {
+			places = append(places, p)
+		}
+	}
+	return NewWormholeGraph(places, g.Wormholes())
+}
+
+// Place returns the place with the given ID, or nil if it is not in the
+// graph.
+func (g *WormholeGraph) Place(id int64) *Place {
+	return g.places[id]
+}
+
+// PlaceIDs returns the sorted IDs of all places of the graph.
+func (g *WormholeGraph) PlaceIDs() []int64 {
+	ids := make([]int64, 0, len(g.places))
+	for id := range g.places {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Links returns the wormholes that can be traversed from a place.
+func (g *WormholeGraph) Links(id int64) []*Wormhole {
+	return g.links[id]
+}
+
+// Wormholes returns each wormhole of the graph once, as it was given.
+func (g *WormholeGraph) Wormholes() []*Wormhole {
+	return g.wormholes
+}
+
+type routeStep struct {
+	placeID  int64
+	distance int
+	index    int
+}
+
+type routeQueue []*routeStep
+
+func (q routeQueue) Len() int { return len(q) }
+
+func (q routeQueue) Less(i, j int) bool { return q[i].distance < q[j].distance }
+
+func (q routeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *routeQueue) Push(x interface{}) {
+	s := x.(*routeStep)
+	s.index = len(*q)
+	*q = append(*q, s)
+}
+
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	s := old[n-1]
+	*q = old[0 : n-1]
+	return s
+}
+
+// ShortestPath returns the cheapest route between two places, using
+// Dijkstra's algorithm; one-way wormholes are only traversed in their
+// direction.
+func (g *WormholeGraph) ShortestPath(from, to int64) (*Route, error) {
+	if g.Place(from) == nil {
+		return nil, mwkerr.New(mwkerr.NoRoute, "Unknown place %d", from)
+	}
+	if g.Place(to) == nil {
+		return nil, mwkerr.New(mwkerr.NoRoute, "Unknown place %d", to)
+	}
+
+	dists := map[int64]int{from: 0}
+	previous := make(map[int64]*Wormhole)
+	done := make(map[int64]bool)
+	q := &routeQueue{}
+	heap.Push(q, &routeStep{placeID: from})
+	for q.Len() > 0 {
+		s := heap.Pop(q).(*routeStep)
+		if done[s.placeID] {
+			continue
+		}
+		done[s.placeID] = true
+		if s.placeID == to {
+			break
+		}
+		for _, w := range g.links[s.placeID] {
+			d := s.distance + w.Cost()
+			if old, ok := dists[w.Destination.ID]; ok && old <= d {
+				continue
+			}
+			dists[w.Destination.ID] = d
+			previous[w.Destination.ID] = w
+			heap.Push(q, &routeStep{placeID: w.Destination.ID, distance: d})
+		}
+	}
+	if !done[to] {
+		return nil, mwkerr.New(mwkerr.NoRoute, "No route from %s to %s", g.Place(from).Name, g.Place(to).Name)
+	}
+
+	route := &Route{Cost: dists[to]}
+	for id := to; id != from; id = previous[id].Source.ID {
+		route.Wormholes = append(route.Wormholes, previous[id])
+	}
+	for i, j := 0, len(route.Wormholes)-1; i < j; i, j = i+1, j-1 {
+		route.Wormholes[i], route.Wormholes[j] = route.Wormholes[j], route.Wormholes[i]
+	}
+	route.Places = append(route.Places, g.Place(from))
+	for _, w := range route.Wormholes {
+		route.Places = append(route.Places, g.Place(w.Destination.ID))
+		route.Distance += w.Distance
+	}
+	return route, nil
+}
+
+// Reachable returns the places that can be reached from a place through at
+// most maxHops wormholes, with the minimal number of hops to reach them.
+func (g *WormholeGraph) Reachable(from int64, maxHops int) map[int64]int {
+	hops := make(map[int64]int)
+	if g.Place(from) == nil {
+		return hops
+	}
+	hops[from] = 0
+	current := []int64{from}
+	for n := 1; n <= maxHops && len(current) > 0; n++ {
+		next := make([]int64, 0)
+		for _, id := range current {
+			for _, w := range g.links[id] {
+				if _, seen := hops[w.Destination.ID]; !seen {
+					hops[w.Destination.ID] = n
+					next = append(next, w.Destination.ID)
+				}
+			}
+		}
+		current = next
+	}
+	return hops
+}
+
+// Components returns the connected components of the graph, as sorted lists
+// of place IDs, largest component first; the direction of one-way wormholes
+// is ignored.
+func (g *WormholeGraph) Components() [][]int64 {
+	components := make([][]int64, 0)
+	seen := make(map[int64]bool)
+	for _, id := range g.PlaceIDs() {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		component := []int64{id}
+		for i := 0; i < len(component); i++ {
+			for _, w := range g.ends[component[i]] {
+				if !seen[w.Destination.ID] {
+					seen[w.Destination.ID] = true
+					component = append(component, w.Destination.ID)
+				}
+			}
+		}
+		sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+		components = append(components, component)
+	}
+	sort.SliceStable(components, func(i, j int) bool { return len(components[i]) > len(components[j]) })
+	return components
+}
+
+// Degree returns the number of wormholes ending at a place, whatever their
+// direction.
+func (g *WormholeGraph) Degree(id int64) int {
+	return len(g.ends[id])
+}
+
+/*
+PathStats returns the diameter of the graph, that is the greatest number of
+hops between two places, and the average number of hops between two places.
+Only pairs of places such that the second can be reached from the first are
+taken into account.
+*/
+func (g *WormholeGraph) PathStats() (int, float64) {
+	ids := g.PlaceIDs()
+	index := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	neighbours := make([][]int, len(ids))
+	for i, id := range ids {
+		for _, w := range g.links[id] {
+			neighbours[i] = append(neighbours[i], index[w.Destination.ID])
+		}
+	}
+
+	diameter, total, pairs := 0, 0, 0
+	hops := make([]int, len(ids))
+	queue := make([]int, 0, len(ids))
+	for start := range ids {
+		for i := range hops {
+			hops[i] = -1
+		}
+		hops[start] = 0
+		queue = append(queue[:0], start)
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, next := range neighbours[current] {
+				if hops[next] < 0 {
+					hops[next] = hops[current] + 1
+					total += hops[next]
+					pairs++
+					if hops[next] > diameter {
+						diameter = hops[next]
+					}
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+	if pairs == 0 {
+		return 0, 0
+	}
+	return diameter, float64(total) / float64(pairs)
+}
+
+/*
+ArticulationPoints returns the places whose removal would disconnect the
+graph, sorted by ID; they are the chokepoints of the wormhole network. The
+direction of one-way wormholes is ignored, and parallel wormholes, like two
+opposite one-way wormholes, are distinct links between their places.
+*/
+func (g *WormholeGraph) ArticulationPoints() []int64 {
+	discovery := make(map[int64]int)
+	low := make(map[int64]int)
+	isCut := make(map[int64]bool)
+	time := 0
+
+	type frame struct {
+		id int64
+		// via is the index of the wormhole followed to reach the place.
+		via      int
+		next     int
+		children int
+	}
+	for _, root := range g.PlaceIDs() {
+		if _, seen := discovery[root]; seen {
+			continue
+		}
+		time++
+		discovery[root], low[root] = time, time
+		stack := []*frame{{id: root, via: -1}}
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+			links := g.ends[f.id]
+			if f.next < len(links) {
+				to := links[f.next].Destination.ID
+				via := g.edges[f.id][f.next]
+				f.next++
+				if via == f.via {
+					continue
+				}
+				if d, seen := discovery[to]; seen {
+					if d < low[f.id] {
+						low[f.id] = d
+					}
+					continue
+				}
+				time++
+				discovery[to], low[to] = time, time
+				f.children++
+				stack = append(stack, &frame{id: to, via: via})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				isCut[f.id] = f.children > 1
+				continue
+			}
+			parent := stack[len(stack)-1]
+			if low[f.id] < low[parent.id] {
+				low[parent.id] = low[f.id]
+			}
+			if len(stack) > 1 && low[f.id] >= discovery[parent.id] {
+				isCut[parent.id] = true
+			}
+		}
+	}
+
+	points := make([]int64, 0)
+	for id, cut := range isCut {
+		if cut {
+			points = append(points, id)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	return points
+}
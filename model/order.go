@@ -0,0 +1,164 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Order is an action a character wants to perform during a turn.
+//
+// Arguments of the order depend on its type, and are stored as JSON.
+type Order struct {
+	ID          int64  `json:"id"`
+	TurnID      int64  `json:"turn_id"`
+	CharacterID int64  `json:"character_id"`
+	Cost        uint   `json:"cost"`
+	Type        string `json:"type"`
+	JSONArgs    string `json:"args"`
+}
+
+// MarshalJSON encodes an order, with its arguments as a JSON object rather
+// than a string.
+func (o *Order) MarshalJSON() ([]byte, error) {
+	type orderJSON struct {
+		ID          int64           `json:"id"`
+		TurnID      int64           `json:"turn_id"`
+		CharacterID int64           `json:"character_id"`
+		Cost        uint            `json:"cost"`
+		Type        string          `json:"type"`
+		Args        json.RawMessage `json:"args"`
+	}
+	return json.Marshal(orderJSON{
+		ID:          o.ID,
+		TurnID:      o.TurnID,
+		CharacterID: o.CharacterID,
+		Cost:        o.Cost,
+		Type:        o.Type,
+		Args:        json.RawMessage(o.JSONArgs),
+	})
+}
+
+// NewOrder creates an order for the given turn, after validating its
+// arguments; its cost is computed from the arguments.
+func NewOrder(db *sql.Tx, c *Character, turnID int64, orderType, jsonArgs string) (*Order, error) {
+	args, err := DecodeOrderArgs(db, c, orderType, jsonArgs)
+	if err != nil {
+		return nil, err
+	}
+	o := &Order{
+		TurnID:      turnID,
+		CharacterID: c.ID,
+		Cost:        args.Cost(),
+		Type:        orderType,
+		JSONArgs:    jsonArgs,
+	}
+	return o, nil
+}
+
+func (o *Order) create(db *sql.Tx) error {
+	result, err := db.Exec(
+		`INSERT INTO orders (turn_id, character_id, cost, order_type, json_args)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		o.TurnID,
+		o.CharacterID,
+		o.Cost,
+		o.Type,
+		o.JSONArgs)
+	if err == nil {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		o.ID = id
+	}
+	return err
+}
+
+func (o *Order) update(db *sql.Tx) error {
+	_, err := db.Exec(
+		"UPDATE orders SET cost = $1, order_type = $2, json_args = $3 WHERE id = $4",
+		o.Cost,
+		o.Type,
+		o.JSONArgs,
+		o.ID)
+	return err
+}
+
+// Save stores an order in database (create or update row).
+func (o *Order) Save(db *sql.Tx) error {
+	if o.ID <= 0 {
+		return o.create(db)
+	}
+	return o.update(db)
+}
+
+// Delete removes an order from database.
+func (o *Order) Delete(db *sql.Tx) error {
+	_, err := db.Exec("DELETE FROM orders WHERE id = $1", o.ID)
+	return err
+}
+
+// LoadOrderByID fetches an order from database by its ID.
+func LoadOrderByID(db *sql.Tx, id int64) (*Order, error) {
+	o := &Order{ID: id}
+	row := db.QueryRow(
+		"SELECT turn_id, character_id, cost, order_type, json_args FROM orders WHERE id = $1",
+		id)
+	err := row.Scan(&o.TurnID, &o.CharacterID, &o.Cost, &o.Type, &o.JSONArgs)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// LoadCharacterOrders fetches the orders given by a character during a turn.
+func LoadCharacterOrders(db *sql.Tx, turnID, characterID int64) ([]*Order, error) {
+	orders, err := LoadOrders(db, turnID)
+	if err != nil {
+		return orders, err
+	}
+	characterOrders := make([]*Order, 0)
+	for _, o := range orders {
+		if o.CharacterID == characterID {
+			characterOrders = append(characterOrders, o)
+		}
+	}
+	return characterOrders, nil
+}
+
+// OrdersCost returns the total cost of the orders given by a character during
+// a turn, ignoring the order with ID exceptID (use 0 to count them all).
+func OrdersCost(db *sql.Tx, turnID, characterID, exceptID int64) (uint, error) {
+	var cost uint
+	row := db.QueryRow(`
+	    SELECT coalesce(sum(cost), 0)
+	      FROM orders
+	     WHERE turn_id = $1
+	       AND character_id = $2
+	       AND id != $3`, turnID, characterID, exceptID)
+	err := row.Scan(&cost)
+	return cost, err
+}
+
+// LoadOrders fetches all orders given during a turn, in the order they were
+// given.
+func LoadOrders(db *sql.Tx, turnID int64) ([]*Order, error) {
+	orders := make([]*Order, 0)
+	rows, err := db.Query(`
+	    SELECT id, character_id, cost, order_type, json_args
+	      FROM orders
+	     WHERE turn_id = $1
+	  ORDER BY id`, turnID)
+	if err != nil {
+		return orders, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		o := &Order{TurnID: turnID}
+		if err := rows.Scan(&o.ID, &o.CharacterID, &o.Cost, &o.Type, &o.JSONArgs); err != nil {
+			return orders, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
@@ -0,0 +1,692 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/morluque/moenawark/mwkerr"
+	"sort"
+)
+
+// OrderArgs holds the decoded arguments of an order; there is one
+// implementation per order type.
+type OrderArgs interface {
+	// Validate checks that the arguments refer to existing objects that the
+	// character is allowed to give orders to.
+	Validate(db *sql.Tx, c *Character) error
+	// Cost returns the number of actions needed to carry out the order.
+	Cost() uint
+}
+
+var orderTypes = map[string]func() OrderArgs{
+	"name":       func() OrderArgs { return &NameOrder{} },
+	"influence":  func() OrderArgs { return &InfluenceOrder{} },
+	"teach":      func() OrderArgs { return &TeachOrder{} },
+	"learn":      func() OrderArgs { return &LearnOrder{} },
+	"experiment": func() OrderArgs { return &ExperimentOrder{} },
+	"separate":   func() OrderArgs { return &SeparateOrder{} },
+	"mix":        func() OrderArgs { return &MixOrder{} },
+	"split":      func() OrderArgs { return &SplitOrder{} },
+	"move":       func() OrderArgs { return &MoveOrder{} },
+	"load":       func() OrderArgs { return &LoadOrder{} },
+	"unload":     func() OrderArgs { return &UnloadOrder{} },
+	"attack":     func() OrderArgs { return &AttackOrder{} },
+	"build":      func() OrderArgs { return &BuildOrder{} },
+}
+
+// OrderTypes returns the sorted list of known order types.
+func OrderTypes() []string {
+	types := make([]string, 0, len(orderTypes))
+	for t := range orderTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+/*
+DecodeOrderArgs decodes and validates the JSON arguments of an order of the
+given type, on behalf of character c.
+
+Any problem with the order is reported as a mwkerr.InvalidOrder error.
+*/
+func DecodeOrderArgs(db *sql.Tx, c *Character, orderType, jsonArgs string) (OrderArgs, error) {
+	newArgs, ok := orderTypes[orderType]
+	if !ok {
+		return nil, mwkerr.New(mwkerr.InvalidOrder, "Unknown order type %q", orderType)
+	}
+	args := newArgs()
+	if err := json.Unmarshal([]byte(jsonArgs), args); err != nil {
+		return nil, mwkerr.New(mwkerr.InvalidOrder, "Bad arguments for %s order: %s", orderType, err.Error())
+	}
+	if err := args.Validate(db, c); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// Args decodes and validates the arguments of an order.
+func (o *Order) Args(db *sql.Tx) (OrderArgs, error) {
+	c, err := LoadCharacterByID(db, o.CharacterID)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeOrderArgs(db, c, o.Type, o.JSONArgs)
+}
+
+func invalidOrder(format string, args ...interface{}) error {
+	return mwkerr.New(mwkerr.InvalidOrder, format, args...)
+}
+
+func exists(db *sql.Tx, query string, args ...interface{}) (bool, error) {
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func checkExists(db *sql.Tx, what string, id int64, query string) error {
+	found, err := exists(db, query, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return invalidOrder("No %s with ID %d", what, id)
+	}
+	return nil
+}
+
+func checkOwnEntity(db *sql.Tx, c *Character, id int64) error {
+	var characterID sql.NullInt64
+	row := db.QueryRow("SELECT character_id FROM entities WHERE resource_id = $1", id)
+	err := row.Scan(&characterID)
+	if err == sql.ErrNoRows {
+		return invalidOrder("No entity with ID %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	if !characterID.Valid || characterID.Int64 != c.ID {
+		return invalidOrder("Entity %d is not controlled by %s", id, c.Name)
+	}
+	return nil
+}
+
+/*
+checkControlled verifies that character c controls an object: an entity of
+its own, a construction crewed by one of its entities, or an object carried by
+such a construction.
+*/
+func checkControlled(db *sql.Tx, c *Character, what string, id int64) error {
+	found, err := exists(db, `
+	    WITH controlled(id) AS (
+	        SELECT resource_id FROM entities WHERE character_id = $1
+	         UNION
+	        SELECT f.construction_id
+	          FROM construction_freight f,
+	               entities e
+	         WHERE f.object_id = e.resource_id
+	           AND e.character_id = $1)
+	    SELECT count(*)
+	      FROM controlled c
+	     WHERE c.id = $2
+	        OR EXISTS (SELECT 1
+	                     FROM construction_freight f
+	                    WHERE f.construction_id = c.id
+	                      AND f.object_id = $2)`, c.ID, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return invalidOrder("%s %d is not controlled by %s", what, id, c.Name)
+	}
+	return nil
+}
+
+// placeOf returns the ID of the place where an object is.
+func placeOf(db *sql.Tx, id int64) (int64, error) {
+	var placeID int64
+	err := db.QueryRow("SELECT place_id FROM objects WHERE id = $1", id).Scan(&placeID)
+	if err == sql.ErrNoRows {
+		return 0, invalidOrder("No object with ID %d", id)
+	}
+	return placeID, err
+}
+
+// checkSamePlace verifies that the object of ID otherID is at the same place
+// as the object of ID id.
+func checkSamePlace(db *sql.Tx, id, otherID int64) error {
+	placeID, err := placeOf(db, id)
+	if err != nil {
+		return err
+	}
+	otherPlaceID, err := placeOf(db, otherID)
+	if err != nil {
+		return err
+	}
+	if placeID != otherPlaceID {
+		return invalidOrder("Object %d is not at the same place as %d", otherID, id)
+	}
+	return nil
+}
+
+func checkEntity(db *sql.Tx, id int64) error {
+	return checkExists(db, "entity", id, "SELECT count(*) FROM entities WHERE resource_id = $1")
+}
+
+func checkConstruction(db *sql.Tx, id int64) error {
+	return checkExists(db, "construction", id, "SELECT count(*) FROM constructions WHERE resource_id = $1")
+}
+
+func checkResource(db *sql.Tx, id int64) error {
+	return checkExists(db, "resource", id, "SELECT count(*) FROM resources WHERE object_id = $1")
+}
+
+func checkObject(db *sql.Tx, id int64) error {
+	return checkExists(db, "object", id, "SELECT count(*) FROM objects WHERE id = $1")
+}
+
+func checkKnowledgeDomain(db *sql.Tx, id int64) error {
+	return checkExists(db, "knowledge domain", id, "SELECT count(*) FROM knowledge_domains WHERE id = $1")
+}
+
+func checkAtom(db *sql.Tx, id int64) error {
+	return checkExists(db, "atom", id, "SELECT count(*) FROM atoms WHERE id = $1")
+}
+
+// checkGroup verifies that id is either an entity or a construction, and
+// returns its group count.
+func checkGroup(db *sql.Tx, id int64) (uint, error) {
+	var count uint
+	row := db.QueryRow(`
+	    SELECT group_count FROM entities WHERE resource_id = $1
+	     UNION ALL
+	    SELECT group_count FROM constructions WHERE resource_id = $1`, id)
+	err := row.Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, invalidOrder("No entity or construction with ID %d", id)
+	}
+	return count, err
+}
+
+// isGroup returns true if the object of an ID is an entity or a construction.
+func isGroup(db *sql.Tx, id int64) (bool, error) {
+	return exists(db, `
+	    SELECT count(*)
+	      FROM (SELECT resource_id FROM entities
+	             UNION ALL
+	            SELECT resource_id FROM constructions)
+	     WHERE resource_id = $1`, id)
+}
+
+// checkPlainResource verifies that a resource is neither an entity nor a
+// construction.
+func checkPlainResource(db *sql.Tx, id int64) error {
+	if err := checkResource(db, id); err != nil {
+		return err
+	}
+	group, err := isGroup(db, id)
+	if err != nil {
+		return err
+	}
+	if group {
+		return invalidOrder("Resource %d is an entity or a construction", id)
+	}
+	return nil
+}
+
+// NameOrder gives a name to an entity or a construction.
+type NameOrder struct {
+	SubjectID int64  `json:"subject_id"`
+	Name      string `json:"name"`
+}
+
+// Validate checks a NameOrder.
+func (o *NameOrder) Validate(db *sql.Tx, c *Character) error {
+	if len(o.Name) == 0 {
+		return invalidOrder("Name is empty")
+	}
+	if _, err := checkGroup(db, o.SubjectID); err != nil {
+		return err
+	}
+	return checkControlled(db, c, "Group", o.SubjectID)
+}
+
+// Cost of a NameOrder.
+func (o *NameOrder) Cost() uint {
+	return 1
+}
+
+// InfluenceOrder makes an entity influence another one.
+type InfluenceOrder struct {
+	InfluencerID int64 `json:"influencer_id"`
+	TargetID     int64 `json:"target_id"`
+}
+
+// Validate checks an InfluenceOrder.
+func (o *InfluenceOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkOwnEntity(db, c, o.InfluencerID); err != nil {
+		return err
+	}
+	if o.InfluencerID == o.TargetID {
+		return invalidOrder("Entity %d can't influence itself", o.TargetID)
+	}
+	if err := checkEntity(db, o.TargetID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.InfluencerID, o.TargetID)
+}
+
+// Cost of an InfluenceOrder.
+func (o *InfluenceOrder) Cost() uint {
+	return 2
+}
+
+// TeachOrder makes an entity teach a knowledge domain to another one.
+type TeachOrder struct {
+	TeacherID int64 `json:"teacher_id"`
+	DomainID  int64 `json:"domain_id"`
+	StudentID int64 `json:"student_id"`
+}
+
+// Validate checks a TeachOrder.
+func (o *TeachOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkOwnEntity(db, c, o.TeacherID); err != nil {
+		return err
+	}
+	if o.TeacherID == o.StudentID {
+		return invalidOrder("Entity %d can't teach itself", o.TeacherID)
+	}
+	if err := checkKnowledgeDomain(db, o.DomainID); err != nil {
+		return err
+	}
+	if err := checkEntity(db, o.StudentID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.TeacherID, o.StudentID)
+}
+
+// Cost of a TeachOrder.
+func (o *TeachOrder) Cost() uint {
+	return 1
+}
+
+// LearnOrder makes an entity learn from another one.
+type LearnOrder struct {
+	StudentID int64 `json:"student_id"`
+	TeacherID int64 `json:"teacher_id"`
+}
+
+// Validate checks a LearnOrder.
+func (o *LearnOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkOwnEntity(db, c, o.StudentID); err != nil {
+		return err
+	}
+	if o.TeacherID == o.StudentID {
+		return invalidOrder("Entity %d can't learn from itself", o.StudentID)
+	}
+	if err := checkEntity(db, o.TeacherID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.StudentID, o.TeacherID)
+}
+
+// Cost of a LearnOrder.
+func (o *LearnOrder) Cost() uint {
+	return 1
+}
+
+// ExperimentOrder makes an entity experiment in a knowledge domain.
+type ExperimentOrder struct {
+	StudentID int64 `json:"student_id"`
+	DomainID  int64 `json:"domain_id"`
+}
+
+// Validate checks an ExperimentOrder.
+func (o *ExperimentOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkOwnEntity(db, c, o.StudentID); err != nil {
+		return err
+	}
+	return checkKnowledgeDomain(db, o.DomainID)
+}
+
+// Cost of an ExperimentOrder.
+func (o *ExperimentOrder) Cost() uint {
+	return 2
+}
+
+// SeparateOrder extracts some quantity of an atom from a resource.
+type SeparateOrder struct {
+	SubjectID int64 `json:"subject_id"`
+	AtomID    int64 `json:"atom_id"`
+	Quantity  int   `json:"quantity"`
+}
+
+// Validate checks a SeparateOrder.
+func (o *SeparateOrder) Validate(db *sql.Tx, c *Character) error {
+	if o.Quantity <= 0 {
+		return invalidOrder("Quantity must be positive, got %d", o.Quantity)
+	}
+	if err := checkResource(db, o.SubjectID); err != nil {
+		return err
+	}
+	if err := checkControlled(db, c, "Resource", o.SubjectID); err != nil {
+		return err
+	}
+	if err := checkAtom(db, o.AtomID); err != nil {
+		return err
+	}
+	var available int
+	row := db.QueryRow(`
+	    SELECT coalesce(sum(m.quantity), 0)
+	      FROM resource_components rc,
+	           matters m
+	     WHERE rc.resource_id = $1
+	       AND rc.matter_id = m.id
+	       AND m.atom_id = $2`, o.SubjectID, o.AtomID)
+	if err := row.Scan(&available); err != nil {
+		return err
+	}
+	if available < o.Quantity {
+		return invalidOrder("Resource %d only holds %d of atom %d", o.SubjectID, available, o.AtomID)
+	}
+	return nil
+}
+
+// Cost of a SeparateOrder.
+func (o *SeparateOrder) Cost() uint {
+	return 1
+}
+
+// MixOrder mixes a resource into another one.
+type MixOrder struct {
+	SubjectID int64 `json:"subject_id"`
+	ObjectID  int64 `json:"object_id"`
+}
+
+// Validate checks a MixOrder.
+func (o *MixOrder) Validate(db *sql.Tx, c *Character) error {
+	if o.SubjectID == o.ObjectID {
+		return invalidOrder("Resource %d can't be mixed with itself", o.SubjectID)
+	}
+	for _, id := range []int64{o.SubjectID, o.ObjectID} {
+		if err := checkResource(db, id); err != nil {
+			return err
+		}
+		if err := checkControlled(db, c, "Resource", id); err != nil {
+			return err
+		}
+	}
+	if err := checkPlainResource(db, o.ObjectID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.SubjectID, o.ObjectID)
+}
+
+// Cost of a MixOrder.
+func (o *MixOrder) Cost() uint {
+	return 1
+}
+
+// SplitOrder splits a group of entities or constructions in two.
+type SplitOrder struct {
+	SubjectID int64 `json:"subject_id"`
+	Quantity  uint  `json:"quantity"`
+}
+
+// Validate checks a SplitOrder.
+func (o *SplitOrder) Validate(db *sql.Tx, c *Character) error {
+	if o.Quantity == 0 {
+		return invalidOrder("Quantity must be positive")
+	}
+	count, err := checkGroup(db, o.SubjectID)
+	if err != nil {
+		return err
+	}
+	if err := checkControlled(db, c, "Group", o.SubjectID); err != nil {
+		return err
+	}
+	if o.Quantity >= count {
+		return invalidOrder("Can't split %d out of a group of %d", o.Quantity, count)
+	}
+	return nil
+}
+
+// Cost of a SplitOrder.
+func (o *SplitOrder) Cost() uint {
+	return 1
+}
+
+// MoveOrder moves a construction to a place, through as many wormholes as
+// needed.
+type MoveOrder struct {
+	SubjectID     int64 `json:"subject_id"`
+	DestinationID int64 `json:"destination_id"`
+	route         *Route
+}
+
+/*
+Validate checks a MoveOrder.
+
+The construction must be crewed by one of the character's entities. The
+destination must be known to the character, and reachable from the place
+where the construction currently is with no more steps than the construction's
+movement; each wormhole takes one step, or more if it is costly.
+*/
+func (o *MoveOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkConstruction(db, o.SubjectID); err != nil {
+		return err
+	}
+	if err := checkControlled(db, c, "Construction", o.SubjectID); err != nil {
+		return err
+	}
+	var placeID int64
+	var movement int
+	row := db.QueryRow(`
+	    SELECT o.place_id, c.movement
+	      FROM objects o,
+	           constructions c
+	     WHERE o.id = c.resource_id
+	       AND c.resource_id = $1`, o.SubjectID)
+	if err := row.Scan(&placeID, &movement); err != nil {
+		return err
+	}
+	if placeID == o.DestinationID {
+		return invalidOrder("Construction %d is already at place %d", o.SubjectID, placeID)
+	}
+
+	g, err := LoadWormholeGraph(db)
+	if err != nil {
+		return err
+	}
+	v, err := LoadVisibility(db, c.ID)
+	if err != nil {
+		return err
+	}
+	v[placeID] = true
+	route, err := g.Restrict(v).ShortestPath(placeID, o.DestinationID)
+	if err != nil {
+		return invalidOrder("Can't move construction %d: %s", o.SubjectID, err.Error())
+	}
+	if route.Steps() > movement {
+		return invalidOrder(
+			"Construction %d can only move %d steps, but %d are needed",
+			o.SubjectID, movement, route.Steps())
+	}
+	o.route = route
+	return nil
+}
+
+// Cost of a MoveOrder is the number of steps along its route.
+func (o *MoveOrder) Cost() uint {
+	if o.route == nil {
+		return 1
+	}
+	return uint(o.route.Steps())
+}
+
+// LoadOrder loads a freight into a construction.
+type LoadOrder struct {
+	ContainerID int64 `json:"container_id"`
+	FreightID   int64 `json:"freight_id"`
+}
+
+/*
+Validate checks a LoadOrder.
+
+The freight must be at the place of the construction and not be loaded
+anywhere yet. It must be controlled by the character too, unless it is a
+resource that is neither an entity nor a construction, which anyone can pick
+up.
+*/
+func (o *LoadOrder) Validate(db *sql.Tx, c *Character) error {
+	if o.ContainerID == o.FreightID {
+		return invalidOrder("Construction %d can't load itself", o.ContainerID)
+	}
+	if err := checkConstruction(db, o.ContainerID); err != nil {
+		return err
+	}
+	if err := checkControlled(db, c, "Construction", o.ContainerID); err != nil {
+		return err
+	}
+	if err := checkObject(db, o.FreightID); err != nil {
+		return err
+	}
+	if err := checkSamePlace(db, o.ContainerID, o.FreightID); err != nil {
+		return err
+	}
+	loaded, err := exists(db, "SELECT count(*) FROM construction_freight WHERE object_id = $1", o.FreightID)
+	if err != nil {
+		return err
+	}
+	if loaded {
+		return invalidOrder("Object %d is already loaded", o.FreightID)
+	}
+	group, err := isGroup(db, o.FreightID)
+	if err != nil {
+		return err
+	}
+	if group {
+		if err := checkControlled(db, c, "Object", o.FreightID); err != nil {
+			return err
+		}
+	}
+	inside, err := exists(db, `
+	    WITH RECURSIVE contents(id) AS (
+	        SELECT $1
+	         UNION
+	        SELECT f.object_id
+	          FROM construction_freight f,
+	               contents c
+	         WHERE f.construction_id = c.id)
+	    SELECT count(*) FROM contents WHERE id = $2`, o.FreightID, o.ContainerID)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return invalidOrder("Construction %d is inside object %d", o.ContainerID, o.FreightID)
+	}
+	return nil
+}
+
+// Cost of a LoadOrder.
+func (o *LoadOrder) Cost() uint {
+	return 1
+}
+
+// UnloadOrder unloads a freight from a construction.
+type UnloadOrder struct {
+	ContainerID int64 `json:"container_id"`
+	FreightID   int64 `json:"freight_id"`
+}
+
+// Validate checks an UnloadOrder.
+func (o *UnloadOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkConstruction(db, o.ContainerID); err != nil {
+		return err
+	}
+	if err := checkControlled(db, c, "Construction", o.ContainerID); err != nil {
+		return err
+	}
+	found, err := exists(db, `
+	    SELECT count(*)
+	      FROM construction_freight
+	     WHERE construction_id = $1
+	       AND object_id = $2`, o.ContainerID, o.FreightID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return invalidOrder("Construction %d does not hold object %d", o.ContainerID, o.FreightID)
+	}
+	return nil
+}
+
+// Cost of an UnloadOrder.
+func (o *UnloadOrder) Cost() uint {
+	return 1
+}
+
+// AttackOrder makes an entity attack an entity or a construction.
+type AttackOrder struct {
+	AttackerID int64 `json:"attacker_id"`
+	DefenderID int64 `json:"defender_id"`
+}
+
+// Validate checks an AttackOrder.
+func (o *AttackOrder) Validate(db *sql.Tx, c *Character) error {
+	if err := checkOwnEntity(db, c, o.AttackerID); err != nil {
+		return err
+	}
+	if o.AttackerID == o.DefenderID {
+		return invalidOrder("Entity %d can't attack itself", o.AttackerID)
+	}
+	if _, err := checkGroup(db, o.DefenderID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.AttackerID, o.DefenderID)
+}
+
+// Cost of an AttackOrder.
+func (o *AttackOrder) Cost() uint {
+	return 2
+}
+
+// BuildOrder makes an entity build a construction out of a resource.
+type BuildOrder struct {
+	BuilderID        int64 `json:"builder_id"`
+	BomID            int64 `json:"bom_id"`
+	EnergyBuild      int   `json:"energy_build"`
+	Sturdiness       int   `json:"sturdiness"`
+	Attack           int   `json:"attack"`
+	Movement         int   `json:"movement"`
+	StorageVolume    int   `json:"storage_volume"`
+	EnergyStorage    int   `json:"energy_storage"`
+	EnergyHarvesting int   `json:"energy_harvesting"`
+}
+
+// Validate checks a BuildOrder.
+func (o *BuildOrder) Validate(db *sql.Tx, c *Character) error {
+	if o.EnergyBuild <= 0 {
+		return invalidOrder("Energy used to build must be positive, got %d", o.EnergyBuild)
+	}
+	if o.Sturdiness <= 0 {
+		return invalidOrder("Sturdiness must be positive, got %d", o.Sturdiness)
+	}
+	if o.Attack < 0 || o.Movement < 0 || o.StorageVolume < 0 || o.EnergyStorage < 0 || o.EnergyHarvesting < 0 {
+		return invalidOrder("Construction characteristics can't be negative")
+	}
+	if err := checkOwnEntity(db, c, o.BuilderID); err != nil {
+		return err
+	}
+	if err := checkPlainResource(db, o.BomID); err != nil {
+		return err
+	}
+	return checkSamePlace(db, o.BuilderID, o.BomID)
+}
+
+// Cost of a BuildOrder grows with the characteristics of the construction.
+func (o *BuildOrder) Cost() uint {
+	total := o.Sturdiness + o.Attack + o.Movement + o.StorageVolume + o.EnergyStorage + o.EnergyHarvesting
+	return uint(1 + total/10)
+}
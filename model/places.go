@@ -2,8 +2,10 @@ package model
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/morluque/moenawark/mwkerr"
 	"github.com/morluque/moenawark/sqlstore"
+	"math"
 )
 
 // Place represents a place in the universe
@@ -15,14 +17,36 @@ type Place struct {
 	X                int    `json:"x"`
 	Y                int    `json:"y"`
 	EnergyProduction int    `json:"energy_production"`
+	// RegionID is the ID of the region the place belongs to, or zero if
+	// it is outside of any region.
+	RegionID int64 `json:"region_id,omitempty"`
 }
 
-// Wormhole links two places.
+// Kinds of wormholes.
+const (
+	// WormholeNormal is a wormhole that can be traversed both ways.
+	WormholeNormal = "normal"
+	// WormholeJump is a rare long-range wormhole, that can be traversed
+	// both ways and may cross other wormholes.
+	WormholeJump = "jump"
+	// WormholeOneWay is a wormhole that can only be traversed from its
+	// source to its destination.
+	WormholeOneWay = "oneway"
+)
+
+/*
+Wormhole links two places.
+
+Unless it is one-way, it can be traversed both ways. Traversing it costs its
+distance times its cost multiplier.
+*/
 type Wormhole struct {
-	ID          int64 `json:"id"`
-	Source      Place `json:"source"`
-	Destination Place `json:"destination"`
-	Distance    int   `json:"distance"`
+	ID             int64   `json:"id"`
+	Source         Place   `json:"source"`
+	Destination    Place   `json:"destination"`
+	Distance       int     `json:"distance"`
+	Kind           string  `json:"kind"`
+	CostMultiplier float64 `json:"cost_multiplier"`
 }
 
 // NewPlace initializes a new place
@@ -30,13 +54,18 @@ func NewPlace(name string, x, y int) *Place {
 	return &Place{Name: name, X: x, Y: y}
 }
 
+func (p *Place) getRegionID() sql.NullInt64 {
+	return sql.NullInt64{Int64: p.RegionID, Valid: p.RegionID > 0}
+}
+
 func (p *Place) create(db *sql.Tx) error {
 	result, err := db.Exec(
-		"INSERT INTO places (name, x, y, energy_production) VALUES ($1, $2, $3, $4)",
+		"INSERT INTO places (name, x, y, energy_production, region_id) VALUES ($1, $2, $3, $4, $5)",
 		p.Name,
 		p.X,
 		p.Y,
-		p.EnergyProduction)
+		p.EnergyProduction,
+		p.getRegionID())
 	if err == nil {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -49,11 +78,12 @@ func (p *Place) create(db *sql.Tx) error {
 
 func (p *Place) update(db *sql.Tx) error {
 	_, err := db.Exec(
-		"UPDATE places SET name = $1, x = $2, y = $3, energy_production = $4 WHERE id = $5",
+		"UPDATE places SET name = $1, x = $2, y = $3, energy_production = $4, region_id = $5 WHERE id = $6",
 		p.Name,
 		p.X,
 		p.Y,
 		p.EnergyProduction,
+		p.getRegionID(),
 		p.ID)
 	return err
 }
@@ -80,35 +110,154 @@ func (p *Place) Save(db *sql.Tx) error {
 	return nil
 }
 
+// BoundingBox is a rectangle of the universe, bounds included.
+type BoundingBox struct {
+	XMin int `json:"xmin"`
+	YMin int `json:"ymin"`
+	XMax int `json:"xmax"`
+	YMax int `json:"ymax"`
+}
+
+// Contains returns true if the place is inside the bounding box.
+func (b *BoundingBox) Contains(p *Place) bool {
+	return p.X >= b.XMin && p.X <= b.XMax && p.Y >= b.YMin && p.Y <= b.YMax
+}
+
+const placeColumns = "id, name, x, y, energy_production, region_id"
+
+func scanPlace(row interface {
+	Scan(dest ...interface{}) error
+}) (*Place, error) {
+	p := &Place{}
+	var regionID sql.NullInt64
+	err := row.Scan(&p.ID, &p.Name, &p.X, &p.Y, &p.EnergyProduction, &regionID)
+	if err != nil {
+		return nil, err
+	}
+	p.RegionID = regionID.Int64
+	return p, nil
+}
+
 // LoadPlace loads the place at (x, y) coordinate, if it exists.
 func LoadPlace(db *sql.Tx, x, y int) (*Place, error) {
-	var id int64
-	var energyProduction int
-	var name string
+	row := db.QueryRow("SELECT "+placeColumns+" FROM places WHERE x = $1 AND y = $2", x, y)
+	return scanPlace(row)
+}
 
-	row := db.QueryRow("SELECT id, name, energy_production FROM places WHERE x = $1, y = $2", x, y)
-	err := row.Scan(&id, &name, &energyProduction)
+// LoadPlaceByID loads a place by its ID.
+func LoadPlaceByID(db *sql.Tx, id int64) (*Place, error) {
+	row := db.QueryRow("SELECT "+placeColumns+" FROM places WHERE id = $1", id)
+	return scanPlace(row)
+}
+
+// LoadPlaceByName loads a place by its name.
+func LoadPlaceByName(db *sql.Tx, name string) (*Place, error) {
+	row := db.QueryRow("SELECT "+placeColumns+" FROM places WHERE name = $1", name)
+	return scanPlace(row)
+}
+
+// PlaceFilter restricts the places returned by ListPlaces.
+type PlaceFilter struct {
+	// BBox, if not nil, restricts places to a bounding box.
+	BBox *BoundingBox
+	// VisibleTo, if positive, restricts places to those visible to the
+	// character with that ID.
+	VisibleTo int64
+	// RegionID, if positive, restricts places to those of a region.
+	RegionID int64
+}
+
+// ListPlaces loads a list of places from database with pagination.
+func ListPlaces(db *sql.Tx, first, count uint, filter PlaceFilter) ([]*Place, error) {
+	places := make([]*Place, 0, count)
+	q := "SELECT " + placeColumns + " FROM places WHERE 1"
+	args := make([]interface{}, 0)
+	if filter.BBox != nil {
+		q += " AND x >= $1 AND x <= $2 AND y >= $3 AND y <= $4"
+		args = append(args, filter.BBox.XMin, filter.BBox.XMax, filter.BBox.YMin, filter.BBox.YMax)
+	}
+	if filter.VisibleTo > 0 {
+		args = append(args, filter.VisibleTo)
+		q += " AND " + visibleCondition("id", len(args))
+	}
+	if filter.RegionID > 0 {
+		args = append(args, filter.RegionID)
+		q += fmt.Sprintf(" AND region_id = $%d", len(args))
+	}
+	q += fmt.Sprintf(" ORDER BY id LIMIT %d OFFSET %d", count, first)
+	rows, err := db.Query(q, args...)
 	if err != nil {
-		return nil, err
+		return places, err
 	}
-	return &Place{ID: id, X: x, Y: y, EnergyProduction: energyProduction}, nil
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPlace(rows)
+		if err != nil {
+			return places, err
+		}
+		places = append(places, p)
+	}
+	return places, rows.Err()
+}
+
+// CountPlaces returns the number of places in the universe.
+func CountPlaces(db *sql.Tx) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM places").Scan(&n)
+	return n, err
 }
 
-// NewWormhole initializes a new wormhole linking two places.
+// NewWormhole initializes a new normal wormhole linking two places.
 func NewWormhole(source, destination *Place, distance int) *Wormhole {
 	return &Wormhole{
-		Source:      *source,
-		Destination: *destination,
-		Distance:    distance,
+		Source:         *source,
+		Destination:    *destination,
+		Distance:       distance,
+		Kind:           WormholeNormal,
+		CostMultiplier: 1,
+	}
+}
+
+// Reversible returns true if the wormhole can be traversed from its
+// destination to its source.
+func (w *Wormhole) Reversible() bool {
+	return w.Kind != WormholeOneWay
+}
+
+// Cost returns the cost of traversing the wormhole, used to find shortest
+// routes.
+func (w *Wormhole) Cost() int {
+	return int(math.Round(float64(w.Distance) * w.multiplier()))
+}
+
+// Steps returns the number of movement points needed to traverse the
+// wormhole: one, or more for costly wormholes.
+func (w *Wormhole) Steps() int {
+	return int(math.Max(1, math.Ceil(w.multiplier())))
+}
+
+func (w *Wormhole) multiplier() float64 {
+	if w.CostMultiplier <= 0 {
+		return 1
+	}
+	return w.CostMultiplier
+}
+
+func (w *Wormhole) getKind() string {
+	if len(w.Kind) == 0 {
+		return WormholeNormal
 	}
+	return w.Kind
 }
 
 func (w *Wormhole) create(db *sql.Tx) error {
 	result, err := db.Exec(
-		"INSERT INTO wormholes (source_id, destination_id, distance) VALUES ($1, $2, $3)",
+		"INSERT INTO wormholes (source_id, destination_id, distance, kind, cost_multiplier) VALUES ($1, $2, $3, $4, $5)",
 		w.Source.ID,
 		w.Destination.ID,
-		w.Distance)
+		w.Distance,
+		w.getKind(),
+		w.multiplier())
 	if err == nil {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -121,10 +270,12 @@ func (w *Wormhole) create(db *sql.Tx) error {
 
 func (w *Wormhole) update(db *sql.Tx) error {
 	_, err := db.Exec(
-		"UPDATE wormholes SET source_id = $1, destination_id = $2, distance = $3 WHERE id = $5",
+		"UPDATE wormholes SET source_id = $1, destination_id = $2, distance = $3, kind = $4, cost_multiplier = $5 WHERE id = $6",
 		w.Source.ID,
 		w.Destination.ID,
 		w.Distance,
+		w.getKind(),
+		w.multiplier(),
 		w.ID)
 	return err
 }
@@ -150,39 +301,89 @@ func (w *Wormhole) Save(db *sql.Tx) error {
 	return nil
 }
 
-// LoadWormholes loads wormholes that start at the given place.
-func LoadWormholes(db *sql.Tx, source *Place) ([]*Wormhole, error) {
-	wormholes := make([]*Wormhole, 0)
-	sql := `
-     SELECT w.id AS w_id,
-            w.distance AS w_distance,
-				p.id AS p_id,
-            p.name AS p_name,
-            p.x AS p_x,
-            p.y AS p_y,
-            p.energy_production AS p_energy_production
+const wormholeQuery = `
+     SELECT w.id,
+            w.distance,
+            w.kind,
+            w.cost_multiplier,
+            s.id, s.name, s.x, s.y, s.energy_production, s.region_id,
+            d.id, d.name, d.x, d.y, d.energy_production, d.region_id
        FROM wormholes w,
-            places p
-      WHERE p.id = $1
-        AND w.source_id = p.id
-	ORDER BY p_id, w.id`
+            places s,
+            places d
+      WHERE w.source_id = s.id
+        AND w.destination_id = d.id`
 
-	rows, err := db.Query(sql, source.ID)
+func scanWormhole(row interface {
+	Scan(dest ...interface{}) error
+}) (*Wormhole, error) {
+	w := &Wormhole{}
+	s, d := &w.Source, &w.Destination
+	var sRegionID, dRegionID sql.NullInt64
+	err := row.Scan(
+		&w.ID, &w.Distance, &w.Kind, &w.CostMultiplier,
+		&s.ID, &s.Name, &s.X, &s.Y, &s.EnergyProduction, &sRegionID,
+		&d.ID, &d.Name, &d.X, &d.Y, &d.EnergyProduction, &dRegionID)
+	if err != nil {
+		return nil, err
+	}
+	s.RegionID, d.RegionID = sRegionID.Int64, dRegionID.Int64
+	return w, nil
+}
+
+func queryWormholes(db *sql.Tx, q string, args ...interface{}) ([]*Wormhole, error) {
+	wormholes := make([]*Wormhole, 0)
+	rows, err := db.Query(q, args...)
 	if err != nil {
 		return wormholes, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var placeID, wormholeID int64
-		var distance, x, y, energyProduction int
-		var placeName string
-		if err := rows.Scan(&wormholeID, &distance, &placeName, &x, &y, &energyProduction); err != nil {
+		w, err := scanWormhole(rows)
+		if err != nil {
 			return wormholes, err
 		}
-		dest := Place{ID: placeID, Name: placeName, X: x, Y: y, EnergyProduction: energyProduction}
-		w := &Wormhole{Source: *source, Destination: dest, Distance: distance}
 		wormholes = append(wormholes, w)
 	}
+	return wormholes, rows.Err()
+}
+
+// LoadWormhole loads a wormhole by its ID.
+func LoadWormhole(db *sql.Tx, id int64) (*Wormhole, error) {
+	return scanWormhole(db.QueryRow(wormholeQuery+" AND w.id = $1", id))
+}
+
+// ListWormholes loads a list of wormholes from database with pagination; if
+// visibleTo is positive, only wormholes visible to the character with that ID
+// are returned.
+func ListWormholes(db *sql.Tx, first, count uint, visibleTo int64) ([]*Wormhole, error) {
+	limit := fmt.Sprintf(" ORDER BY w.id LIMIT %d OFFSET %d", count, first)
+	if visibleTo > 0 {
+		q := wormholeQuery + " AND " + visibleCondition("s.id", 1) + " AND " + visibleCondition("d.id", 1)
+		return queryWormholes(db, q+limit, visibleTo)
+	}
+	return queryWormholes(db, wormholeQuery+limit)
+}
 
+// LoadWormholes loads wormholes that start at the given place; since
+// wormholes that are not one-way can be traversed both ways, those ending at
+// the place are returned too, with source and destination swapped.
+func LoadWormholes(db *sql.Tx, source *Place) ([]*Wormhole, error) {
+	wormholes, err := queryWormholes(db, wormholeQuery+`
+	    AND (w.source_id = $1 OR (w.destination_id = $1 AND w.kind != 'oneway'))
+	ORDER BY w.id`, source.ID)
+	if err != nil {
+		return wormholes, err
+	}
+	for _, w := range wormholes {
+		if w.Source.ID != source.ID {
+			w.Source, w.Destination = w.Destination, w.Source
+		}
+	}
 	return wormholes, nil
 }
+
+// LoadAllWormholes fetches every wormhole of the universe, ordered by ID.
+func LoadAllWormholes(db *sql.Tx) ([]*Wormhole, error) {
+	return queryWormholes(db, wormholeQuery+" ORDER BY w.id")
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/mwkerr"
+	"github.com/morluque/moenawark/sqlstore"
+)
+
+// Region is a circular part of the universe, denser in places than the rest
+// of it.
+type Region struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Radius int    `json:"radius"`
+}
+
+// NewRegion initializes a new region.
+func NewRegion(name string, x, y, radius int) *Region {
+	return &Region{Name: name, X: x, Y: y, Radius: radius}
+}
+
+func (r *Region) create(db *sql.Tx) error {
+	result, err := db.Exec(
+		"INSERT INTO regions (name, x, y, radius) VALUES ($1, $2, $3, $4)",
+		r.Name,
+		r.X,
+		r.Y,
+		r.Radius)
+	if err == nil {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		r.ID = id
+	}
+	return err
+}
+
+func (r *Region) update(db *sql.Tx) error {
+	_, err := db.Exec(
+		"UPDATE regions SET name = $1, x = $2, y = $3, radius = $4 WHERE id = $5",
+		r.Name,
+		r.X,
+		r.Y,
+		r.Radius,
+		r.ID)
+	return err
+}
+
+// Save stores a region in the database, either creating a row or updating an
+// existing one.
+func (r *Region) Save(db *sql.Tx) error {
+	var err error
+
+	if r.ID <= 0 {
+		err = r.create(db)
+	} else {
+		err = r.update(db)
+	}
+	if err != nil {
+		if sqlstore.IsConstraintError(err) {
+			return mwkerr.New(mwkerr.DuplicateModel, "Duplicate region %s", r.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+const regionColumns = "id, name, x, y, radius"
+
+func scanRegion(row interface {
+	Scan(dest ...interface{}) error
+}) (*Region, error) {
+	r := &Region{}
+	if err := row.Scan(&r.ID, &r.Name, &r.X, &r.Y, &r.Radius); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRegionByID loads a region by its ID.
+func LoadRegionByID(db *sql.Tx, id int64) (*Region, error) {
+	row := db.QueryRow("SELECT "+regionColumns+" FROM regions WHERE id = $1", id)
+	return scanRegion(row)
+}
+
+// LoadRegionByName loads a region by its name.
+func LoadRegionByName(db *sql.Tx, name string) (*Region, error) {
+	row := db.QueryRow("SELECT "+regionColumns+" FROM regions WHERE name = $1", name)
+	return scanRegion(row)
+}
+
+func queryRegions(db *sql.Tx, q string, args ...interface{}) ([]*Region, error) {
+	regions := make([]*Region, 0)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return regions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRegion(rows)
+		if err != nil {
+			return regions, err
+		}
+		regions = append(regions, r)
+	}
+	return regions, rows.Err()
+}
+
+// regionVisibleCondition returns an SQL condition true if the region of ID
+// in column holds a place visible to the character whose ID is the query
+// parameter number argNum.
+func regionVisibleCondition(column string, argNum int) string {
+	return fmt.Sprintf("%s IN (SELECT region_id FROM places WHERE %s)", column, visibleCondition("id", argNum))
+}
+
+/*
+ListRegions fetches a list of regions ordered by ID, with pagination.
+
+If visibleTo is positive, regions are restricted to those holding at least a
+place visible to the character with that ID.
+*/
+func ListRegions(db *sql.Tx, first, count uint, visibleTo int64) ([]*Region, error) {
+	if visibleTo > 0 {
+		return queryRegions(db, fmt.Sprintf(
+			"SELECT "+regionColumns+" FROM regions WHERE "+regionVisibleCondition("id", 1)+" ORDER BY id LIMIT %d OFFSET %d",
+			count, first), visibleTo)
+	}
+	return queryRegions(db, fmt.Sprintf("SELECT "+regionColumns+" FROM regions ORDER BY id LIMIT %d OFFSET %d", count, first))
+}
+
+// VisibleTo returns true if the region holds at least a place visible to the
+// character of an ID.
+func (r *Region) VisibleTo(db *sql.Tx, characterID int64) (bool, error) {
+	var n int
+	row := db.QueryRow("SELECT count(*) FROM regions WHERE id = $1 AND "+regionVisibleCondition("id", 2), r.ID, characterID)
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// LoadAllRegions fetches every region of the universe, ordered by ID.
+func LoadAllRegions(db *sql.Tx) ([]*Region, error) {
+	return queryRegions(db, "SELECT "+regionColumns+" FROM regions ORDER BY id")
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Turn is a game turn; players give orders during a turn, and these orders
+// are resolved when the turn ends.
+type Turn struct {
+	ID        int64     `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// IsOpen returns true if the turn is not finished yet.
+func (t *Turn) IsOpen() bool {
+	return t.EndedAt.IsZero()
+}
+
+// OpenTurn starts a new turn and stores it in database.
+func OpenTurn(db *sql.Tx) (*Turn, error) {
+	now := time.Now()
+	result, err := db.Exec("INSERT INTO turns (started_at) VALUES ($1)", now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Turn{ID: id, StartedAt: time.Unix(now.Unix(), 0)}, nil
+}
+
+// Close marks the turn as finished.
+func (t *Turn) Close(db *sql.Tx) error {
+	now := time.Now()
+	_, err := db.Exec("UPDATE turns SET ended_at = $1 WHERE id = $2", now.Unix(), t.ID)
+	if err != nil {
+		return err
+	}
+	t.EndedAt = time.Unix(now.Unix(), 0)
+	return nil
+}
+
+// LoadCurrentTurn fetches the turn currently open from database; it returns
+// sql.ErrNoRows if there is none.
+func LoadCurrentTurn(db *sql.Tx) (*Turn, error) {
+	var id, startedAt int64
+	row := db.QueryRow(`
+	    SELECT id, started_at
+	      FROM turns
+	     WHERE ended_at IS NULL
+	  ORDER BY id DESC
+	     LIMIT 1`)
+	err := row.Scan(&id, &startedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &Turn{ID: id, StartedAt: time.Unix(startedAt, 0)}, nil
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+/*
+Visibility holds the places a character knows about, by place ID; the value is
+true if the character visited the place, false if it only senses it.
+
+A character visits places where its entities are, and keeps knowledge of them
+forever; it senses places that are a few wormholes away from its entities.
+*/
+type Visibility map[int64]bool
+
+// CanSee returns true if the character knows about the place.
+func (v Visibility) CanSee(placeID int64) bool {
+	_, ok := v[placeID]
+	return ok
+}
+
+// CanSeeWormhole returns true if the character knows about both ends of the
+// wormhole.
+func (v Visibility) CanSeeWormhole(w *Wormhole) bool {
+	return v.CanSee(w.Source.ID) && v.CanSee(w.Destination.ID)
+}
+
+// visibleCondition returns an SQL condition true if the place ID in column is
+// visible to the character whose ID is the query parameter number argNum.
+func visibleCondition(column string, argNum int) string {
+	return fmt.Sprintf(`%[1]s IN (
+	    SELECT place_id
+	      FROM place_visibility
+	     WHERE character_id = $%[2]d
+	       AND turn_id = (SELECT max(turn_id) FROM place_visibility WHERE character_id = $%[2]d))`,
+		column, argNum)
+}
+
+/*
+UpdateVisibility computes and stores what every character knows of the
+universe at the start of a turn.
+
+Places where a character's entities are, and places visited during previous
+turns, are visited; places at most senseHops wormholes away from the entities
+are sensed, following one-way wormholes in their direction only.
+*/
+func UpdateVisibility(db *sql.Tx, turnID int64, senseHops int) error {
+	_, err := db.Exec(`
+	WITH RECURSIVE
+	     links(a, b) AS (
+	         SELECT source_id, destination_id FROM wormholes
+	          UNION
+	         SELECT destination_id, source_id FROM wormholes WHERE kind != 'oneway'),
+	     sensed(character_id, place_id, hops) AS (
+	         SELECT e.character_id, o.place_id, 0
+	           FROM entities e,
+	                objects o
+	          WHERE o.id = e.resource_id
+	            AND e.character_id IS NOT NULL
+	          UNION
+	         SELECT s.character_id, l.b, s.hops + 1
+	           FROM sensed s,
+	                links l
+	          WHERE l.a = s.place_id
+	            AND s.hops < $1)
+	INSERT INTO place_visibility (turn_id, character_id, place_id, visited)
+	     SELECT $2, character_id, place_id, max(hops = 0)
+	       FROM sensed
+	   GROUP BY character_id, place_id`, senseHops, turnID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	INSERT OR REPLACE INTO place_visibility (turn_id, character_id, place_id, visited)
+	     SELECT $1, v.character_id, v.place_id, 1
+	       FROM place_visibility v
+	      WHERE v.visited
+	        AND v.turn_id = (SELECT max(turn_id)
+	                           FROM place_visibility
+	                          WHERE character_id = v.character_id
+	                            AND turn_id < $1)`, turnID)
+	return err
+}
+
+// LoadVisibility fetches what a character currently knows of the universe.
+func LoadVisibility(db *sql.Tx, characterID int64) (Visibility, error) {
+	v := make(Visibility)
+	rows, err := db.Query(`
+	    SELECT place_id, visited
+	      FROM place_visibility
+	     WHERE character_id = $1
+	       AND turn_id = (SELECT max(turn_id) FROM place_visibility WHERE character_id = $1)`, characterID)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var placeID int64
+		var visited bool
+		if err := rows.Scan(&placeID, &visited); err != nil {
+			return v, err
+		}
+		v[placeID] = visited
+	}
+	return v, rows.Err()
+}
@@ -28,6 +28,14 @@ const (
 	DatabaseEmpty
 	// DatabaseAlreadyInitialized signals that you can't init an existing database
 	DatabaseAlreadyInitialized
+	// InvalidOrder signals that an order is malformed or refers to unknown objects
+	InvalidOrder
+	// NoRoute signals that there is no way between two places
+	NoRoute
+	// InvalidUniverse signals an inconsistent universe description
+	InvalidUniverse
+	// CharacterInUse signals that a character can't be deleted while it controls entities
+	CharacterInUse
 )
 
 var log *loglevel.Logger
@@ -18,15 +18,27 @@ func (h AuthHandler) SetResourceMapper(m *resourceMapper) {
 	h.resourceMapper = m
 }
 
-// View handles HTTP GET on an authenticated session (unimplemented).
-func (h AuthHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	log.Debugf("authGet got called")
-	return unknownMethodError(r.Method)
+// View sends JSON of one of the sessions of the authenticated user, by ID.
+func (h AuthHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	sessions, err := session.List(db, r)
+	if err != nil {
+		return authError(err)
+	}
+	for _, s := range sessions {
+		if s.ID == id {
+			return sendJSON(w, s)
+		}
+	}
+	return notFoundError()
 }
 
-// List handles HTTP GET on a collection of authenticated sessions (unimplemented).
+// List sends JSON of the sessions of the authenticated user.
 func (h AuthHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
-	return unknownMethodError(r.Method)
+	sessions, err := session.List(db, r)
+	if err != nil {
+		return authError(err)
+	}
+	return sendJSON(w, sessions)
 }
 
 // Create verifies user credentials on HTTP POST and returns a security token.
@@ -37,23 +49,55 @@ func (h AuthHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return authError(err)
 	}
-	token := session.Create(user)
+	token, err := session.Create(db, user, r)
+	if err != nil {
+		return appError(err)
+	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
 	headers := w.Header()
 	headers[config.Get("auth.token_header")] = []string{token}
 	log.Infof("user %s successfully logged in", login)
 	return nil
 }
 
-// Update handles HTTP PUT on an authenticated session (unimplemented).
-func (h AuthHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	return unknownMethodError(r.Method)
+// Update refreshes the session of the request on HTTP PUT on the collection,
+// returning a new security token that replaces the previous one.
+func (h AuthHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	if len(id) > 0 {
+		return notFoundError()
+	}
+	token, err := session.Refresh(db, r)
+	if err != nil {
+		return authError(err)
+	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
+	headers := w.Header()
+	headers[config.Get("auth.token_header")] = []string{token}
+	return nil
 }
 
-// Delete logs out a user, forgetting about it's authentication token.
-func (h AuthHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, unused string) *httpError {
-	err := session.Delete(r)
+/*
+Delete logs out a user, forgetting about it's authentication token.
+
+Given a session ID, it revokes that session of the user instead, for example
+one left open on another device.
+*/
+func (h AuthHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	var err error
+	if len(id) == 0 {
+		err = session.Delete(db, r)
+	} else {
+		err = session.Revoke(db, r, id)
+	}
 	if err != nil {
 		return authError(err)
 	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
 	return nil
 }
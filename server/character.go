@@ -2,7 +2,13 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/morluque/moenawark/config"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
 	"net/http"
+	"strconv"
 )
 
 // CharacterHandler is a resource handler for in-game characters.
@@ -15,27 +21,212 @@ func (h CharacterHandler) SetResourceMapper(m *resourceMapper) {
 	h.resourceMapper = m
 }
 
-// View reponds with JSON representing an in-game character controlled by a user.
-func (h CharacterHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	return unknownMethodError(r.Method)
+// View reponds with JSON representing an in-game character controlled by a
+// user; the character is designated by its name or its ID.
+func (h CharacterHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	c, herr := h.loadCharacter(db, id)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster && !h.isOwnCharacter(user, c) {
+		return authError(fmt.Errorf("can only get info about your own character"))
+	}
+	return sendJSON(w, c)
 }
 
 // List will list all characters as JSON.
 func (h CharacterHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
-	return unknownMethodError(r.Method)
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster {
+		return authError(fmt.Errorf("Only game masters can list all characters"))
+	}
+	start, count := listGetLimit(r)
+	characters, err := model.ListCharacters(db, start, count)
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, characters)
 }
 
-// Create adds a new character to the database.
+// Create adds a new character to the database, controlled by the
+// authenticated user; a user can only have one character.
 func (h CharacterHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
-	return unknownMethodError(r.Method)
+	type characterCreateParams struct {
+		Name string `json:"name"`
+	}
+
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	if user.HasCharacter() {
+		return userError(fmt.Errorf("You already have character %s", user.Character.Name))
+	}
+
+	data, herr := readBodyData(r)
+	if herr != nil {
+		return herr
+	}
+	body := characterCreateParams{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return userError(fmt.Errorf("Error decoding JSON: %s", err.Error()))
+	}
+	if len(body.Name) <= 0 {
+		return userError(fmt.Errorf("Name is empty"))
+	}
+	if herr := h.checkName(body.Name); herr != nil {
+		return herr
+	}
+
+	c := model.NewCharacter(
+		body.Name,
+		uint(config.GetInt("character.initial_power")),
+		uint(config.GetInt("character.initial_actions")))
+	if herr := h.saveCharacter(db, c); herr != nil {
+		return herr
+	}
+	user.Character = c
+	if err := user.Save(db); err != nil {
+		return appError(fmt.Errorf("Error while linking character %s to user %s: %s", c.Name, user.Login, err.Error()))
+	}
+	if err := db.Commit(); err != nil {
+		return appError(fmt.Errorf("Database error: %s", err.Error()))
+	}
+	log.Infof("Character %s created for user %s", c.Name, user.Login)
+	return sendJSON(w, c)
+}
+
+/*
+Update modifies a character from the user-supplied JSON body.
+
+Players can rename their own character; only game masters can change power
+and actions.
+*/
+func (h CharacterHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	type characterUpdateParams struct {
+		Name    string `json:"name"`
+		Power   *uint  `json:"power"`
+		Actions *uint  `json:"actions"`
+	}
+
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	c, herr := h.loadCharacter(db, id)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster && !h.isOwnCharacter(user, c) {
+		return authError(fmt.Errorf("can only modify your own character"))
+	}
+
+	data, herr := readBodyData(r)
+	if herr != nil {
+		return herr
+	}
+	nc := characterUpdateParams{}
+	if err := json.Unmarshal(data, &nc); err != nil {
+		return userError(fmt.Errorf("Error decoding JSON: %s", err.Error()))
+	}
+	if (nc.Power != nil || nc.Actions != nil) && !user.GameMaster {
+		return authError(fmt.Errorf("Only game masters can change power and actions"))
+	}
+	if len(nc.Name) > 0 {
+		if herr := h.checkName(nc.Name); herr != nil {
+			return herr
+		}
+		c.Name = nc.Name
+	}
+	if nc.Power != nil {
+		c.Power = *nc.Power
+	}
+	if nc.Actions != nil {
+		c.Actions = *nc.Actions
+	}
+
+	if herr := h.saveCharacter(db, c); herr != nil {
+		return herr
+	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, c)
+}
+
+// Delete removes a character from database; players can delete their own
+// character, as long as it controls no entity.
+func (h CharacterHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	c, herr := h.loadCharacter(db, id)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster && !h.isOwnCharacter(user, c) {
+		return authError(fmt.Errorf("can only delete your own character"))
+	}
+
+	if err := c.Delete(db); err != nil {
+		if merr, ok := err.(mwkerr.MWKError); ok && merr.Code == mwkerr.CharacterInUse {
+			return userError(err)
+		}
+		return appError(err)
+	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
+	log.Infof("Character %s deleted.", c.Name)
+
+	return nil
+}
+
+func (h CharacterHandler) isOwnCharacter(user *model.User, c *model.Character) bool {
+	return user.HasCharacter() && user.Character.ID == c.ID
+}
+
+// checkName rejects names that loadCharacter would take for an ID.
+func (h CharacterHandler) checkName(name string) *httpError {
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return userError(fmt.Errorf("Name %s is a number, it can't be told apart from a character ID", name))
+	}
+	return nil
 }
 
-// Update modifies a character from the user-supplied JSON body.
-func (h CharacterHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	return unknownMethodError(r.Method)
+func (h CharacterHandler) loadCharacter(db *sql.Tx, id string) (*model.Character, *httpError) {
+	var c *model.Character
+	var err error
+	if charID, perr := strconv.ParseInt(id, 10, 64); perr == nil {
+		c, err = model.LoadCharacterByID(db, charID)
+	} else {
+		c, err = model.LoadCharacter(db, id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFoundError()
+		}
+		return nil, appError(err)
+	}
+	return c, nil
 }
 
-// Delete would delete a character from database if it was implemented.
-func (h CharacterHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, unused string) *httpError {
-	return unknownMethodError(r.Method)
+func (h CharacterHandler) saveCharacter(db *sql.Tx, c *model.Character) *httpError {
+	err := c.Save(db)
+	if err != nil {
+		merr, ok := err.(mwkerr.MWKError)
+		if ok && merr.Code == mwkerr.DuplicateModel {
+			return userError(err)
+		}
+		return appError(fmt.Errorf("Error while saving character %s: %s", c.Name, err.Error()))
+	}
+	return nil
 }
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/universe"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// MapHandler is a read-only resource handler drawing maps of the universe,
+// for game masters to preview it.
+type MapHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h MapHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+/*
+View sends a map of the whole universe in the format given as ID, "svg" or
+"png"; game masters only.
+
+The "scale" parameter sets the number of pixels per universe unit, and the
+"character" parameter (name) highlights what a character knows of the
+universe.
+*/
+func (h MapHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster {
+		return authError(fmt.Errorf("Only game masters can see the map of the whole universe"))
+	}
+	var write func(*universe.Universe, io.Writer, universe.RenderOptions) error
+	var contentType string
+	switch id {
+	case "svg":
+		write = (*universe.Universe).WriteSVG
+		contentType = "image/svg+xml"
+	case "png":
+		write = (*universe.Universe).WritePNG
+		contentType = "image/png"
+	default:
+		return notFoundError()
+	}
+	opts, herr := h.renderOptions(db, r)
+	if herr != nil {
+		return herr
+	}
+
+	u, err := universe.Load(db)
+	if err != nil {
+		return appError(err)
+	}
+	if err := u.CheckMapSize(*opts); err != nil {
+		return userError(err)
+	}
+	var buf bytes.Buffer
+	if err := write(u, &buf, *opts); err != nil {
+		return appError(err)
+	}
+	w.Header().Add("Content-Type", contentType)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// List sends the SVG map of the universe, like View on "svg".
+func (h MapHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return h.View(db, w, r, "svg")
+}
+
+// Create is not allowed, maps are drawn from the universe.
+func (h MapHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, maps are drawn from the universe.
+func (h MapHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, maps are drawn from the universe.
+func (h MapHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+func (h MapHandler) renderOptions(db *sql.Tx, r *http.Request) (*universe.RenderOptions, *httpError) {
+	opts := &universe.RenderOptions{Scale: 1}
+	if str := r.FormValue("scale"); len(str) > 0 {
+		scale, err := strconv.ParseFloat(str, 64)
+		if err != nil || scale <= 0 || scale > universe.MaxMapScale {
+			return nil, userError(fmt.Errorf("Bad value %q for scale", str))
+		}
+		opts.Scale = scale
+	}
+	if name := r.FormValue("character"); len(name) > 0 {
+		c, err := model.LoadCharacter(db, name)
+		if err == sql.ErrNoRows {
+			return nil, userError(fmt.Errorf("Unknown character %s", name))
+		}
+		if err != nil {
+			return nil, appError(err)
+		}
+		if opts.Highlight, err = model.LoadVisibility(db, c.ID); err != nil {
+			return nil, appError(err)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/markov"
+	"net/http"
+	"strconv"
+)
+
+const maxNameSuggestions = 50
+
+// NameHandler is a read-only resource handler suggesting names generated from
+// the registered name corpora.
+type NameHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h NameHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of a list of distinct names generated from a corpus; the
+// "count" parameter sets how many, 5 by default.
+func (h NameHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	if _, herr := loadSessionUser(db, r); herr != nil {
+		return herr
+	}
+	count := 5
+	if str := r.FormValue("count"); len(str) > 0 {
+		n, err := strconv.Atoi(str)
+		if err != nil || n <= 0 || n > maxNameSuggestions {
+			return userError(fmt.Errorf("Bad number of names %q, must be between 1 and %d", str, maxNameSuggestions))
+		}
+		count = n
+	}
+	rules, err := markov.ConfiguredRules()
+	if err != nil {
+		return appError(err)
+	}
+	g, err := markov.NewCorpusNameGenerator(id, rules)
+	if err != nil {
+		return notFoundError()
+	}
+	names := make([]string, 0, count)
+	taken := make(map[string]bool)
+	for len(names) < count {
+		name, err := g.Generate(taken)
+		if err != nil {
+			break
+		}
+		taken[name] = true
+		names = append(names, name)
+	}
+	return sendJSON(w, names)
+}
+
+// List sends JSON of the names of the registered corpora.
+func (h NameHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	if _, herr := loadSessionUser(db, r); herr != nil {
+		return herr
+	}
+	return sendJSON(w, markov.CorpusNames())
+}
+
+// Create is not allowed, names are generated.
+func (h NameHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, names are generated.
+func (h NameHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, names are generated.
+func (h NameHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
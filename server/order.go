@@ -0,0 +1,243 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
+	"net/http"
+	"strconv"
+)
+
+// OrderHandler is a resource handler for the orders given by characters
+// during the current turn.
+type OrderHandler struct {
+	*resourceMapper
+}
+
+type orderParams struct {
+	Type string          `json:"type"`
+	Args json.RawMessage `json:"args"`
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h OrderHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of an order given by the authenticated user's character.
+func (h OrderHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	o, herr := h.loadOrder(db, id)
+	if herr != nil {
+		return herr
+	}
+	if !user.GameMaster && (!user.HasCharacter() || o.CharacterID != user.Character.ID) {
+		return authError(fmt.Errorf("can only view your own orders"))
+	}
+	return sendJSON(w, o)
+}
+
+/*
+List sends JSON of the orders given during the current turn.
+
+Players get their character's orders, game masters get the orders of all
+characters.
+*/
+func (h OrderHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	t, herr := h.loadCurrentTurn(db)
+	if herr != nil {
+		return herr
+	}
+	var orders []*model.Order
+	var err error
+	if user.GameMaster {
+		orders, err = model.LoadOrders(db, t.ID)
+	} else if user.HasCharacter() {
+		orders, err = model.LoadCharacterOrders(db, t.ID, user.Character.ID)
+	} else {
+		return authError(fmt.Errorf("you have no character"))
+	}
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, orders)
+}
+
+// Create checks user-supplied JSON and adds an order for the current turn.
+func (h OrderHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	if !user.HasCharacter() {
+		return authError(fmt.Errorf("you have no character to give orders to"))
+	}
+	t, herr := h.loadCurrentTurn(db)
+	if herr != nil {
+		return herr
+	}
+	params, herr := h.readParams(r)
+	if herr != nil {
+		return herr
+	}
+
+	o, err := model.NewOrder(db, user.Character, t.ID, params.Type, string(params.Args))
+	if err != nil {
+		return orderError(err)
+	}
+	if herr := h.checkCost(db, user.Character, o); herr != nil {
+		return herr
+	}
+	if err = o.Save(db); err != nil {
+		return appError(fmt.Errorf("Error while saving order: %s", err.Error()))
+	}
+	if err = db.Commit(); err != nil {
+		return appError(fmt.Errorf("Database error: %s", err.Error()))
+	}
+	log.Infof("Character %s gave %s order %d", user.Character.Name, o.Type, o.ID)
+	return sendJSON(w, o)
+}
+
+// Update amends an order of the current turn from user-supplied JSON.
+func (h OrderHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, o, herr := h.loadOwnPendingOrder(db, r, id)
+	if herr != nil {
+		return herr
+	}
+	params, herr := h.readParams(r)
+	if herr != nil {
+		return herr
+	}
+
+	amended, err := model.NewOrder(db, user.Character, o.TurnID, params.Type, string(params.Args))
+	if err != nil {
+		return orderError(err)
+	}
+	amended.ID = o.ID
+	if herr := h.checkCost(db, user.Character, amended); herr != nil {
+		return herr
+	}
+	if err = amended.Save(db); err != nil {
+		return appError(fmt.Errorf("Error while saving order %d: %s", o.ID, err.Error()))
+	}
+	if err = db.Commit(); err != nil {
+		return appError(err)
+	}
+	log.Infof("Character %s amended order %d", user.Character.Name, o.ID)
+	return sendJSON(w, amended)
+}
+
+// Delete cancels an order of the current turn.
+func (h OrderHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, o, herr := h.loadOwnPendingOrder(db, r, id)
+	if herr != nil {
+		return herr
+	}
+	if err := o.Delete(db); err != nil {
+		return appError(err)
+	}
+	if err := db.Commit(); err != nil {
+		return appError(err)
+	}
+	log.Infof("Character %s cancelled order %d", user.Character.Name, o.ID)
+	return nil
+}
+
+func (h OrderHandler) loadCurrentTurn(db *sql.Tx) (*model.Turn, *httpError) {
+	t, err := model.LoadCurrentTurn(db)
+	if err == sql.ErrNoRows {
+		return nil, userError(fmt.Errorf("No turn is open"))
+	}
+	if err != nil {
+		return nil, appError(err)
+	}
+	return t, nil
+}
+
+func (h OrderHandler) loadOrder(db *sql.Tx, id string) (*model.Order, *httpError) {
+	orderID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, notFoundError()
+	}
+	o, err := model.LoadOrderByID(db, orderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFoundError()
+		}
+		return nil, appError(err)
+	}
+	return o, nil
+}
+
+// loadOwnPendingOrder loads an order that the authenticated user's character
+// gave during the current turn, and can thus still modify.
+func (h OrderHandler) loadOwnPendingOrder(db *sql.Tx, r *http.Request, id string) (*model.User, *model.Order, *httpError) {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return nil, nil, herr
+	}
+	o, herr := h.loadOrder(db, id)
+	if herr != nil {
+		return nil, nil, herr
+	}
+	if !user.HasCharacter() || o.CharacterID != user.Character.ID {
+		return nil, nil, authError(fmt.Errorf("can only modify your own orders"))
+	}
+	t, herr := h.loadCurrentTurn(db)
+	if herr != nil {
+		return nil, nil, herr
+	}
+	if o.TurnID != t.ID {
+		return nil, nil, userError(fmt.Errorf("Order %d belongs to a finished turn", o.ID))
+	}
+	return user, o, nil
+}
+
+func (h OrderHandler) readParams(r *http.Request) (*orderParams, *httpError) {
+	data, herr := readBodyData(r)
+	if herr != nil {
+		return nil, herr
+	}
+	params := orderParams{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, userError(fmt.Errorf("Error decoding JSON: %s", err.Error()))
+	}
+	if len(params.Args) == 0 {
+		params.Args = json.RawMessage("{}")
+	}
+	return &params, nil
+}
+
+// checkCost verifies that the character has enough actions left for the
+// order, taking into account the other orders given during the turn.
+func (h OrderHandler) checkCost(db *sql.Tx, c *model.Character, o *model.Order) *httpError {
+	spent, err := model.OrdersCost(db, o.TurnID, c.ID, o.ID)
+	if err != nil {
+		return appError(err)
+	}
+	if spent+o.Cost > c.Actions {
+		var left uint
+		if spent < c.Actions {
+			left = c.Actions - spent
+		}
+		return userError(mwkerr.New(
+			mwkerr.InvalidOrder, "Order costs %d actions but only %d are left", o.Cost, left))
+	}
+	return nil
+}
+
+func orderError(err error) *httpError {
+	if merr, ok := err.(mwkerr.MWKError); ok && merr.Code == mwkerr.InvalidOrder {
+		return userError(err)
+	}
+	return appError(err)
+}
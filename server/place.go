@@ -0,0 +1,148 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"net/http"
+	"strconv"
+)
+
+// PlaceHandler is a read-only resource handler for the places of the universe.
+type PlaceHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h PlaceHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of a place, designated by its name or its ID, along with
+// the wormholes leaving it; players only see places and wormholes their
+// character knows about.
+func (h PlaceHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	type placeView struct {
+		*model.Place
+		Wormholes []*model.Wormhole `json:"wormholes"`
+	}
+
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	v, herr := loadVisibility(db, user)
+	if herr != nil {
+		return herr
+	}
+	p, herr := h.loadPlace(db, id)
+	if herr != nil {
+		return herr
+	}
+	if v != nil && !v.CanSee(p.ID) {
+		return notFoundError()
+	}
+	wormholes, err := model.LoadWormholes(db, p)
+	if err != nil {
+		return appError(err)
+	}
+	if v != nil {
+		wormholes = filterWormholes(v, wormholes)
+	}
+	return sendJSON(w, placeView{Place: p, Wormholes: wormholes})
+}
+
+/*
+List sends JSON of a list of places, with pagination.
+
+Places can be restricted to a bounding box with the xmin, ymin, xmax and ymax
+parameters, which must then all be given, and to a region with the region
+parameter (ID). Players only get places their character knows about.
+*/
+func (h PlaceHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	filter := model.PlaceFilter{}
+	if !user.GameMaster {
+		if !user.HasCharacter() {
+			return sendJSON(w, []*model.Place{})
+		}
+		filter.VisibleTo = user.Character.ID
+	}
+	filter.BBox, herr = h.boundingBox(r)
+	if herr != nil {
+		return herr
+	}
+	if str := r.FormValue("region"); len(str) > 0 {
+		regionID, err := strconv.ParseInt(str, 10, 64)
+		if err != nil || regionID <= 0 {
+			return userError(fmt.Errorf("Bad value %q for region", str))
+		}
+		filter.RegionID = regionID
+	}
+	start, count := listGetLimit(r)
+	places, err := model.ListPlaces(db, start, count, filter)
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, places)
+}
+
+// Create is not allowed, places are created with the universe.
+func (h PlaceHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, places are created with the universe.
+func (h PlaceHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, places are created with the universe.
+func (h PlaceHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+func (h PlaceHandler) loadPlace(db *sql.Tx, id string) (*model.Place, *httpError) {
+	var p *model.Place
+	var err error
+	if placeID, perr := strconv.ParseInt(id, 10, 64); perr == nil {
+		p, err = model.LoadPlaceByID(db, placeID)
+	} else {
+		p, err = model.LoadPlaceByName(db, id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFoundError()
+		}
+		return nil, appError(err)
+	}
+	return p, nil
+}
+
+func (h PlaceHandler) boundingBox(r *http.Request) (*model.BoundingBox, *httpError) {
+	names := []string{"xmin", "ymin", "xmax", "ymax"}
+	values := make([]int, len(names))
+	given := 0
+	for i, name := range names {
+		str := r.FormValue(name)
+		if len(str) == 0 {
+			continue
+		}
+		v, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, userError(fmt.Errorf("Bad value %q for %s", str, name))
+		}
+		values[i] = v
+		given++
+	}
+	if given == 0 {
+		return nil, nil
+	}
+	if given != len(names) {
+		return nil, userError(fmt.Errorf("Bounding box needs xmin, ymin, xmax and ymax"))
+	}
+	return &model.BoundingBox{XMin: values[0], YMin: values[1], XMax: values[2], YMax: values[3]}, nil
+}
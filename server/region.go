@@ -0,0 +1,112 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/morluque/moenawark/model"
+	"net/http"
+	"strconv"
+)
+
+// RegionHandler is a read-only resource handler for the regions of the
+// universe.
+type RegionHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h RegionHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of a region, designated by its name or its ID, along with
+// its places; players only see regions and places their character knows
+// about.
+func (h RegionHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	type regionView struct {
+		*model.Region
+		Places []*model.Place `json:"places"`
+	}
+
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	region, herr := h.loadRegion(db, id)
+	if herr != nil {
+		return herr
+	}
+	filter := model.PlaceFilter{RegionID: region.ID}
+	if !user.GameMaster {
+		if !user.HasCharacter() {
+			return notFoundError()
+		}
+		visible, err := region.VisibleTo(db, user.Character.ID)
+		if err != nil {
+			return appError(err)
+		}
+		if !visible {
+			return notFoundError()
+		}
+		filter.VisibleTo = user.Character.ID
+	}
+	start, count := listGetLimit(r)
+	places, err := model.ListPlaces(db, start, count, filter)
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, regionView{Region: region, Places: places})
+}
+
+// List sends JSON of a list of regions, with pagination; players only get
+// regions holding places their character knows about.
+func (h RegionHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	var visibleTo int64
+	if !user.GameMaster {
+		if !user.HasCharacter() {
+			return sendJSON(w, []*model.Region{})
+		}
+		visibleTo = user.Character.ID
+	}
+	start, count := listGetLimit(r)
+	regions, err := model.ListRegions(db, start, count, visibleTo)
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, regions)
+}
+
+// Create is not allowed, regions are created with the universe.
+func (h RegionHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, regions are created with the universe.
+func (h RegionHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, regions are created with the universe.
+func (h RegionHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+func (h RegionHandler) loadRegion(db *sql.Tx, id string) (*model.Region, *httpError) {
+	var region *model.Region
+	var err error
+	if regionID, perr := strconv.ParseInt(id, 10, 64); perr == nil {
+		region, err = model.LoadRegionByID(db, regionID)
+	} else {
+		region, err = model.LoadRegionByName(db, id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFoundError()
+		}
+		return nil, appError(err)
+	}
+	return region, nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
+	"net/http"
+	"strconv"
+)
+
+// RouteHandler is a read-only resource handler answering routing queries on
+// the wormhole network.
+type RouteHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h RouteHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of the connected components of the wormhole network, as
+// lists of place IDs, when called on "components"; game masters only.
+func (h RouteHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	if id != "components" {
+		return notFoundError()
+	}
+	if !user.GameMaster {
+		return authError(fmt.Errorf("Only game masters can see the whole wormhole network"))
+	}
+	g, err := model.LoadWormholeGraph(db)
+	if err != nil {
+		return appError(err)
+	}
+	return sendJSON(w, g.Components())
+}
+
+/*
+List sends JSON of a route between the places whose IDs are given by the
+"from" and "to" parameters.
+
+If "hops" is given instead of "to", it sends the places reachable from "from"
+through at most that many wormholes, with the number of wormholes to reach
+them. Players only get routes through places their character knows about.
+*/
+func (h RouteHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	v, herr := loadVisibility(db, user)
+	if herr != nil {
+		return herr
+	}
+	g, err := model.LoadWormholeGraph(db)
+	if err != nil {
+		return appError(err)
+	}
+	if v != nil {
+		g = g.Restrict(v)
+	}
+
+	from, herr := h.placeParam(r, "from")
+	if herr != nil {
+		return herr
+	}
+	if len(r.FormValue("hops")) > 0 {
+		hops, err := strconv.Atoi(r.FormValue("hops"))
+		if err != nil || hops < 0 {
+			return userError(fmt.Errorf("Bad number of hops %q", r.FormValue("hops")))
+		}
+		return sendJSON(w, g.Reachable(from, hops))
+	}
+	to, herr := h.placeParam(r, "to")
+	if herr != nil {
+		return herr
+	}
+	route, err := g.ShortestPath(from, to)
+	if err != nil {
+		if merr, ok := err.(mwkerr.MWKError); ok && merr.Code == mwkerr.NoRoute {
+			return userError(err)
+		}
+		return appError(err)
+	}
+	return sendJSON(w, route)
+}
+
+// Create is not allowed, routes are computed.
+func (h RouteHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, routes are computed.
+func (h RouteHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, routes are computed.
+func (h RouteHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+func (h RouteHandler) placeParam(r *http.Request, name string) (int64, *httpError) {
+	str := r.FormValue(name)
+	if len(str) == 0 {
+		return 0, userError(fmt.Errorf("Missing parameter %s", name))
+	}
+	id, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, userError(fmt.Errorf("Bad place ID %q for %s", str, name))
+	}
+	return id, nil
+}
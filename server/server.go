@@ -9,10 +9,14 @@ import (
 	"fmt"
 	"github.com/morluque/moenawark/config"
 	"github.com/morluque/moenawark/loglevel"
+	"github.com/morluque/moenawark/model"
 	"github.com/morluque/moenawark/mwkerr"
+	"github.com/morluque/moenawark/server/session"
 	"github.com/morluque/moenawark/sqlstore"
+	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 )
 
 const (
@@ -34,8 +38,9 @@ func (e *httpError) MarshalJSON() ([]byte, error) {
 	if err, ok := e.Err.(mwkerr.MWKError); ok {
 		return json.Marshal(err)
 	}
-	j := fmt.Sprintf(`{"error":"%s"}`, e.Err.Error())
-	return []byte(j), nil
+	return json.Marshal(struct {
+		Error string `json:"error"`
+	}{e.Err.Error()})
 }
 
 type resourceHandler interface {
@@ -128,6 +133,11 @@ func (srv *apiServerV1) handlerFuncFor(h resourceHandler, resourceName, prefix s
 			return
 		}
 
+		if err := srv.touchSession(r); err != nil {
+			sendError(w, appError(err))
+			return
+		}
+
 		// Open DB transaction for create/update/delete
 		tx, err := srv.db.BeginTx(r.Context(), nil)
 		if err != nil {
@@ -166,6 +176,20 @@ func (srv *apiServerV1) handlerFuncFor(h resourceHandler, resourceName, prefix s
 	}
 }
 
+// touchSession records that the session of an authenticated request is still
+// in use, in its own transaction since handlers don't commit on read.
+func (srv *apiServerV1) touchSession(r *http.Request) error {
+	tx, err := srv.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := session.Touch(tx, r); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (srv *apiServerV1) register(resourceName, prefix string, h resourceHandler) {
 	srv.resourceMap[resourceName] = prefix
 	srv.resources[resourceName] = h
@@ -179,14 +203,107 @@ func ServeHTTP() {
 	}
 	defer db.Close()
 
+	store, err := session.NewConfiguredStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	session.SetStore(store)
+
 	srv1 := newAPIServerV1(db)
 	srv1.register("user", "user", UserHandler{})
 	srv1.register("auth", "auth", AuthHandler{})
 	srv1.register("character", "character", CharacterHandler{})
+	srv1.register("order", "order", OrderHandler{})
+	srv1.register("region", "region", RegionHandler{})
+	srv1.register("place", "place", PlaceHandler{})
+	srv1.register("wormhole", "wormhole", WormholeHandler{})
+	srv1.register("route", "route", RouteHandler{})
+	srv1.register("map", "map", MapHandler{})
+	srv1.register("name", "name", NameHandler{})
 
 	http.ListenAndServe(config.Get("http_listen"), srv1.ServeMux())
 }
 
+// loadSessionUser returns the authenticated user, reloaded from database so
+// that its character is up to date.
+func loadSessionUser(db *sql.Tx, r *http.Request) (*model.User, *httpError) {
+	user, err := session.User(db, r)
+	if err != nil {
+		return nil, authError(err)
+	}
+	u, err := model.LoadUser(db, user.Login)
+	if err != nil {
+		return nil, authError(err)
+	}
+	return u, nil
+}
+
+// loadVisibility returns what a user's character knows of the universe, or nil
+// for game masters, who know everything.
+func loadVisibility(db *sql.Tx, user *model.User) (model.Visibility, *httpError) {
+	if user.GameMaster {
+		return nil, nil
+	}
+	if !user.HasCharacter() {
+		return make(model.Visibility), nil
+	}
+	v, err := model.LoadVisibility(db, user.Character.ID)
+	if err != nil {
+		return nil, appError(err)
+	}
+	return v, nil
+}
+
+// listGetLimit reads pagination parameters "start" and "count" from the
+// request, defaulting to the first 100 items.
+func listGetLimit(r *http.Request) (uint, uint) {
+	var (
+		start uint
+		count uint = 100
+	)
+	startStr := r.FormValue("start")
+	if len(startStr) > 0 {
+		i, err := strconv.Atoi(startStr)
+		if err == nil && i >= 0 {
+			start = uint(i)
+		}
+	}
+	countStr := r.FormValue("count")
+	if len(countStr) > 0 {
+		i, err := strconv.Atoi(countStr)
+		if err == nil && i > 0 {
+			count = uint(i)
+		}
+	}
+	return start, count
+}
+
+func readBodyData(r *http.Request) ([]byte, *httpError) {
+	if r.ContentLength <= 0 {
+		return nil, userError(fmt.Errorf("Empty request body"))
+	}
+	if r.ContentLength > MaxBodyLength {
+		return nil, userError(fmt.Errorf("Request body too long, max length is %d", MaxBodyLength))
+	}
+	data := make([]byte, r.ContentLength)
+	_, err := io.ReadFull(r.Body, data)
+	if err != nil {
+		return nil, appError(fmt.Errorf("Error while reading request body: %s", err.Error()))
+	}
+	return data, nil
+}
+
+func sendJSON(w http.ResponseWriter, v interface{}) *httpError {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return appError(err)
+	}
+	headers := w.Header()
+	headers.Add("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
+	return nil
+}
+
 func sendError(w http.ResponseWriter, e *httpError) {
 	if e.Err == nil {
 		e.Err = fmt.Errorf(e.Message)
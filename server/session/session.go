@@ -2,124 +2,313 @@ package session
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/morluque/moenawark/config"
 	"github.com/morluque/moenawark/loglevel"
 	"github.com/morluque/moenawark/model"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// lastSeenGranularity is how stale the last time a session was seen must be
+// before it is updated, to avoid writing on every request; it is shortened for
+// short idle timeouts.
+const lastSeenGranularity = time.Minute
+
 var (
-	log             *loglevel.Logger
-	sessionList     = make(map[string]session)
-	sessionLock     = sync.RWMutex{}
-	sessionDuration = time.Hour * 2
+	log         *loglevel.Logger
+	sessionLock = sync.RWMutex{}
+	idleTimeout = time.Hour
+	lifetime    = time.Hour * 24
 )
 
+var store SessionStore = NewMemoryStore()
+
 func init() {
 	log = loglevel.New("session", loglevel.Debug)
 }
 
-// ReloadConfig performs required actions to reload all dynamic config.
+/*
+ReloadConfig performs required actions to reload all dynamic config.
+
+Sessions expire when unused for auth.session_idle_timeout, or at the latest
+auth.session_lifetime after login; a zero duration means no limit. The former
+auth.session_duration is read as an idle timeout.
+*/
 func ReloadConfig() {
-	str := config.Get("auth.session_duration")
-	d, err := time.ParseDuration(str)
+	idleKey := "auth.session_idle_timeout"
+	if len(config.Get("auth.session_duration")) > 0 {
+		log.Warnf("auth.session_duration is deprecated, use auth.session_idle_timeout and auth.session_lifetime")
+		idleKey = "auth.session_duration"
+	}
+	idle, err := parseDuration(idleKey)
+	if err != nil {
+		return
+	}
+	life, err := parseDuration("auth.session_lifetime")
 	if err != nil {
-		log.Errorf("invalid session duration %s, keeping previous value", str)
 		return
 	}
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
-	sessionDuration = d
+	idleTimeout = idle
+	lifetime = life
 }
 
-type session struct {
-	user  *model.User
-	since time.Time
+func parseDuration(key string) (time.Duration, error) {
+	str := config.Get(key)
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		log.Errorf("invalid session duration %s = %s, keeping previous values", key, str)
+		return 0, fmt.Errorf("invalid duration %q", str)
+	}
+	return d, nil
 }
 
-// Create associates a user with a security token, and returns that new token.
-func Create(user *model.User) string {
-	reapSessions()
-	token := createAuthToken()
-	s := session{user: user, since: time.Now()}
+// SetStore sets where sessions are kept; they are kept in memory by default.
+func SetStore(s SessionStore) {
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
-	sessionList[token] = s
+	store = s
+}
+
+/*
+NewConfiguredStore returns the SessionStore set by auth.session_store in
+config: "sql" for the sessions table of the database, or "memory".
+*/
+func NewConfiguredStore() (SessionStore, error) {
+	switch name := config.Get("auth.session_store"); name {
+	case "sql":
+		return SQLStore{}, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", name)
+	}
+}
 
-	return token
+func getStore() SessionStore {
+	sessionLock.RLock()
+	defer sessionLock.RUnlock()
+	return store
 }
 
-// Delete forgets about a user/token pair.
+func getDurations() (time.Duration, time.Duration) {
+	sessionLock.RLock()
+	defer sessionLock.RUnlock()
+	return idleTimeout, lifetime
+}
+
+// Session is an authenticated session of a user. Its ID is the hash of its
+// token, so that tokens are never stored.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	// Current is set on the session of the request when listing sessions.
+	Current bool `json:"current"`
+	user    *model.User
+}
+
+// Create associates a user with a security token, and returns that new token.
+func Create(tx *sql.Tx, user *model.User, r *http.Request) (string, error) {
+	if err := reapSessions(tx); err != nil {
+		return "", err
+	}
+	token := createAuthToken()
+	now := time.Now()
+	s := &Session{
+		ID:         hashToken(token),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		UserAgent:  r.UserAgent(),
+		IP:         remoteIP(r),
+		user:       user,
+	}
+	st := getStore()
+	if err := st.Save(tx, s); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Delete forgets about the session of the request.
 //
 // The user will not be considered authenticated any more.
-func Delete(r *http.Request) error {
-	token, err := getAuthToken(r)
+func Delete(tx *sql.Tx, r *http.Request) error {
+	s, err := current(tx, r)
 	if err != nil {
 		return err
 	}
-	sessionLock.Lock()
-	defer sessionLock.Unlock()
-	delete(sessionList, *token)
-	log.Debugf("session %s deleted", token)
+	st := getStore()
+	if err := st.Delete(tx, s.ID); err != nil {
+		return err
+	}
+	log.Debugf("session %s deleted", s.ID)
 	return nil
 }
 
 // User returns the authenticated user for this request, if any.
-func User(r *http.Request) (*model.User, error) {
-	token, err := getAuthToken(r)
+func User(tx *sql.Tx, r *http.Request) (*model.User, error) {
+	s, err := current(tx, r)
 	if err != nil {
 		return nil, err
 	}
-	s, err := getSession(*token)
+	return s.user, nil
+}
+
+// List returns the sessions of the authenticated user for this request.
+func List(tx *sql.Tx, r *http.Request) ([]*Session, error) {
+	s, err := current(tx, r)
 	if err != nil {
 		return nil, err
 	}
-	if isExpiredSession(time.Now(), *s) {
-		return nil, fmt.Errorf("session %s expired", *token)
+	st := getStore()
+	sessions, err := st.ListUser(tx, s.UserID)
+	if err != nil {
+		return nil, err
 	}
-	return s.user, nil
+	now := time.Now()
+	active := make([]*Session, 0, len(sessions))
+	for _, other := range sessions {
+		if isExpiredSession(now, other) {
+			continue
+		}
+		other.Current = other.ID == s.ID
+		active = append(active, other)
+	}
+	return active, nil
 }
 
-func isExpiredSession(now time.Time, s session) bool {
-	return now.After(s.since.Add(sessionDuration))
+// Revoke forgets about a session of the authenticated user for this request,
+// by ID.
+func Revoke(tx *sql.Tx, r *http.Request, id string) error {
+	s, err := current(tx, r)
+	if err != nil {
+		return err
+	}
+	st := getStore()
+	other, err := st.Load(tx, id)
+	if err != nil || other.UserID != s.UserID {
+		return fmt.Errorf("no such session %s", id)
+	}
+	if err := st.Delete(tx, id); err != nil {
+		return err
+	}
+	log.Debugf("session %s revoked", id)
+	return nil
 }
 
-func getSession(token string) (*session, error) {
-	sessionLock.RLock()
-	defer sessionLock.RUnlock()
-	s, ok := sessionList[token]
-	if !ok {
-		return nil, fmt.Errorf("no such session %s", token)
+// Touch records that the session of the request, if any, is still in use,
+// which delays its idle expiry.
+func Touch(tx *sql.Tx, r *http.Request) error {
+	s, err := current(tx, r)
+	if err != nil {
+		return nil
+	}
+	granularity := lastSeenGranularity
+	if idle, _ := getDurations(); idle > 0 && idle/2 < granularity {
+		granularity = idle / 2
+	}
+	now := time.Now()
+	if now.Sub(s.LastSeenAt) < granularity {
+		return nil
 	}
-	return &s, nil
+	return getStore().Touch(tx, s.ID, now)
 }
 
-func getExpiredTokens() []string {
-	tokens := make([]string, 0)
-	now := time.Now()
+/*
+Refresh replaces the token of the session of the request with a new one, and
+returns it; the previous token can't be used any more.
 
-	sessionLock.RLock()
-	defer sessionLock.RUnlock()
-	for t, s := range sessionList {
-		if isExpiredSession(now, s) {
-			tokens = append(tokens, t)
-		}
+The session keeps its creation time, so that it still expires at the end of
+its lifetime.
+*/
+func Refresh(tx *sql.Tx, r *http.Request) (string, error) {
+	s, err := current(tx, r)
+	if err != nil {
+		return "", err
 	}
+	st := getStore()
+	if err := st.Delete(tx, s.ID); err != nil {
+		return "", err
+	}
+	token := createAuthToken()
+	previousID := s.ID
+	s.ID = hashToken(token)
+	s.LastSeenAt = time.Now()
+	s.UserAgent = r.UserAgent()
+	s.IP = remoteIP(r)
+	if err := st.Save(tx, s); err != nil {
+		return "", err
+	}
+	log.Debugf("session %s refreshed as %s", previousID, s.ID)
+	return token, nil
+}
 
-	return tokens
+// current returns the unexpired session of the request.
+func current(tx *sql.Tx, r *http.Request) (*Session, error) {
+	token, err := getAuthToken(r)
+	if err != nil {
+		return nil, err
+	}
+	id := hashToken(*token)
+	st := getStore()
+	s, err := st.Load(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("no such session %s", id)
+	}
+	if isExpiredSession(time.Now(), s) {
+		return nil, fmt.Errorf("session %s expired", id)
+	}
+	return s, nil
 }
 
-func reapSessions() {
-	expiredTokens := getExpiredTokens()
-	sessionLock.Lock()
-	defer sessionLock.Unlock()
-	for _, t := range expiredTokens {
-		delete(sessionList, t)
+func isExpiredSession(now time.Time, s *Session) bool {
+	createdBefore, seenBefore := expiryLimits(now)
+	return s.CreatedAt.Before(createdBefore) || s.LastSeenAt.Before(seenBefore)
+}
+
+// expiryLimits returns the times before which sessions expired, by creation
+// and by last use; the zero time if there is no limit.
+func expiryLimits(now time.Time) (time.Time, time.Time) {
+	idle, life := getDurations()
+	var createdBefore, seenBefore time.Time
+	if life > 0 {
+		createdBefore = now.Add(-life)
+	}
+	if idle > 0 {
+		seenBefore = now.Add(-idle)
+	}
+	return createdBefore, seenBefore
+}
+
+func reapSessions(tx *sql.Tx) error {
+	createdBefore, seenBefore := expiryLimits(time.Now())
+	return getStore().DeleteExpired(tx, createdBefore, seenBefore)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
 }
 
 func getAuthToken(r *http.Request) (*string, error) {
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"database/sql"
+	"github.com/morluque/moenawark/model"
+	"time"
+)
+
+// SQLStore is a SessionStore keeping sessions in the sessions table, so that
+// they survive restarts and are shared by server processes.
+type SQLStore struct{}
+
+// Save stores a session, replacing any session of the same ID.
+func (SQLStore) Save(tx *sql.Tx, s *Session) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO sessions (token_hash, user_id, created_at, last_seen_at, user_agent, ip)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		s.ID,
+		s.UserID,
+		s.CreatedAt.Unix(),
+		s.LastSeenAt.Unix(),
+		s.UserAgent,
+		s.IP)
+	return err
+}
+
+// Load returns the session of an ID, with its user loaded from database.
+func (SQLStore) Load(tx *sql.Tx, id string) (*Session, error) {
+	row := tx.QueryRow(
+		`SELECT s.token_hash, s.user_id, s.created_at, s.last_seen_at, s.user_agent, s.ip, u.login
+		   FROM sessions s
+		   JOIN users u ON u.id = s.user_id
+		  WHERE s.token_hash = $1`,
+		id)
+	var login string
+	s, err := scanSession(row, &login)
+	if err != nil {
+		return nil, err
+	}
+	s.user, err = model.LoadUser(tx, login)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Delete forgets about the session of an ID.
+func (SQLStore) Delete(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM sessions WHERE token_hash = $1", id)
+	return err
+}
+
+// ListUser returns the sessions of a user, the oldest first.
+func (SQLStore) ListUser(tx *sql.Tx, userID int64) ([]*Session, error) {
+	rows, err := tx.Query(
+		`SELECT token_hash, user_id, created_at, last_seen_at, user_agent, ip
+		   FROM sessions
+		  WHERE user_id = $1
+		  ORDER BY created_at, token_hash`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Touch sets the last time the session of an ID was used.
+func (SQLStore) Touch(tx *sql.Tx, id string, t time.Time) error {
+	_, err := tx.Exec("UPDATE sessions SET last_seen_at = $1 WHERE token_hash = $2", t.Unix(), id)
+	return err
+}
+
+// DeleteExpired forgets about sessions created before a time or last used
+// before another.
+func (SQLStore) DeleteExpired(tx *sql.Tx, createdBefore, seenBefore time.Time) error {
+	_, err := tx.Exec(
+		"DELETE FROM sessions WHERE created_at < $1 OR last_seen_at < $2",
+		createdBefore.Unix(),
+		seenBefore.Unix())
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row scanner, extra ...interface{}) (*Session, error) {
+	var s Session
+	var createdAt, lastSeenAt int64
+	dest := append([]interface{}{&s.ID, &s.UserID, &createdAt, &lastSeenAt, &s.UserAgent, &s.IP}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	s.CreatedAt = time.Unix(createdAt, 0)
+	s.LastSeenAt = time.Unix(lastSeenAt, 0)
+	return &s, nil
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+SessionStore keeps sessions by their ID, the hash of their token.
+
+Methods are given the transaction of the request being served, so that
+stores backed by the database share it; other stores ignore it.
+*/
+type SessionStore interface {
+	Save(tx *sql.Tx, s *Session) error
+	Load(tx *sql.Tx, id string) (*Session, error)
+	Delete(tx *sql.Tx, id string) error
+	// ListUser returns the sessions of a user, the oldest first.
+	ListUser(tx *sql.Tx, userID int64) ([]*Session, error)
+	// Touch sets the last time the session of an ID was used.
+	Touch(tx *sql.Tx, id string, t time.Time) error
+	// DeleteExpired forgets about sessions created before a time or last
+	// used before another.
+	DeleteExpired(tx *sql.Tx, createdBefore, seenBefore time.Time) error
+}
+
+// MemoryStore is a SessionStore keeping sessions in memory; they are lost
+// when the server stops.
+type MemoryStore struct {
+	sessions map[string]Session
+	lock     sync.RWMutex
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]Session)}
+}
+
+// Save stores a session, replacing any session of the same ID.
+func (m *MemoryStore) Save(tx *sql.Tx, s *Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+// Load returns the session of an ID.
+func (m *MemoryStore) Load(tx *sql.Tx, id string) (*Session, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("no such session %s", id)
+	}
+	return &s, nil
+}
+
+// Delete forgets about the session of an ID.
+func (m *MemoryStore) Delete(tx *sql.Tx, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// ListUser returns the sessions of a user, the oldest first.
+func (m *MemoryStore) ListUser(tx *sql.Tx, userID int64) ([]*Session, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	sessions := make([]*Session, 0)
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			s := s
+			sessions = append(sessions, &s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+// Touch sets the last time the session of an ID was used.
+func (m *MemoryStore) Touch(tx *sql.Tx, id string, t time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return fmt.Errorf("no such session %s", id)
+	}
+	s.LastSeenAt = t
+	m.sessions[id] = s
+	return nil
+}
+
+// DeleteExpired forgets about sessions created before a time or last used
+// before another.
+func (m *MemoryStore) DeleteExpired(tx *sql.Tx, createdBefore, seenBefore time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id, s := range m.sessions {
+		if s.CreatedAt.Before(createdBefore) || s.LastSeenAt.Before(seenBefore) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
@@ -7,9 +7,7 @@ import (
 	"github.com/morluque/moenawark/model"
 	"github.com/morluque/moenawark/mwkerr"
 	"github.com/morluque/moenawark/server/session"
-	"io"
 	"net/http"
-	"strconv"
 )
 
 // UserHandler is a resource handler for users.
@@ -24,7 +22,7 @@ func (h UserHandler) SetResourceMapper(m *resourceMapper) {
 
 // View sends JSON of a user in response to HTTP GET
 func (h UserHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	user, err := session.User(r)
+	user, err := session.User(db, r)
 	if err != nil {
 		return authError(err)
 	}
@@ -46,16 +44,17 @@ func (h UserHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, lo
 	return nil
 }
 
-// List sends JSON of a list of users on HTTP GET
+// List sends JSON of a list of users on HTTP GET, paginated with the "start"
+// and "count" parameters.
 func (h UserHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
-	user, err := session.User(r)
+	user, err := session.User(db, r)
 	if err != nil {
 		return authError(err)
 	}
 	if !user.GameMaster {
 		return authError(fmt.Errorf("Only game masters can list all users"))
 	}
-	start, count := h.userListGetLimit(r)
+	start, count := listGetLimit(r)
 	users, err := model.ListUsers(db, start, count)
 	if err != nil {
 		return appError(err)
@@ -79,7 +78,7 @@ func (h UserHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request)
 		Password2 string `json:"password2"`
 	}
 
-	data, herr := h.readBodyData(r)
+	data, herr := readBodyData(r)
 	if herr != nil {
 		return herr
 	}
@@ -127,7 +126,7 @@ func (h UserHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request,
 		GameMaster bool   `json:"game_master"`
 	}
 
-	user, err := session.User(r)
+	user, err := session.User(db, r)
 	if err != nil {
 		return authError(err)
 	}
@@ -140,7 +139,7 @@ func (h UserHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request,
 		return herr
 	}
 
-	data, herr := h.readBodyData(r)
+	data, herr := readBodyData(r)
 	if herr != nil {
 		return herr
 	}
@@ -195,7 +194,7 @@ func (h UserHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request,
 // Delete would delete a user from database, but is unimplemented.
 // TODO: implement for new users (users that never were active).
 func (h UserHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, login string) *httpError {
-	user, err := session.User(r)
+	user, err := session.User(db, r)
 	if err != nil {
 		return authError(err)
 	}
@@ -230,40 +229,3 @@ func (h UserHandler) loadUserFromLogin(db *sql.Tx, login string) (*model.User, *
 	}
 	return u, nil
 }
-
-func (h UserHandler) userListGetLimit(r *http.Request) (uint, uint) {
-	var (
-		start uint
-		count uint = 100
-	)
-	startStr := r.FormValue("start")
-	if len(startStr) <= 0 {
-		i, err := strconv.Atoi(startStr)
-		if err == nil && i >= 0 {
-			start = uint(i)
-		}
-	}
-	countStr := r.FormValue("count")
-	if len(countStr) <= 0 {
-		i, err := strconv.Atoi(countStr)
-		if err == nil && i > 0 {
-			count = uint(i)
-		}
-	}
-	return start, count
-}
-
-func (h UserHandler) readBodyData(r *http.Request) ([]byte, *httpError) {
-	if r.ContentLength <= 0 {
-		return nil, userError(fmt.Errorf("Empty request body"))
-	}
-	if r.ContentLength > MaxBodyLength {
-		return nil, userError(fmt.Errorf("Request body too long, max length is %d", MaxBodyLength))
-	}
-	data := make([]byte, r.ContentLength)
-	_, err := io.ReadFull(r.Body, data)
-	if err != nil {
-		return nil, appError(fmt.Errorf("Error while reading request body: %s", err.Error()))
-	}
-	return data, nil
-}
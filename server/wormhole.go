@@ -0,0 +1,125 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"net/http"
+	"strconv"
+)
+
+// WormholeHandler is a read-only resource handler for the wormholes linking
+// places of the universe.
+type WormholeHandler struct {
+	*resourceMapper
+}
+
+// SetResourceMapper sets the resourceMapper that can be used to create URLs to arbitrary resources.
+func (h WormholeHandler) SetResourceMapper(m *resourceMapper) {
+	h.resourceMapper = m
+}
+
+// View sends JSON of a wormhole, if the user's character knows about it.
+func (h WormholeHandler) View(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	v, herr := loadVisibility(db, user)
+	if herr != nil {
+		return herr
+	}
+	wormholeID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return notFoundError()
+	}
+	wormhole, err := model.LoadWormhole(db, wormholeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFoundError()
+		}
+		return appError(err)
+	}
+	if v != nil && !v.CanSeeWormhole(wormhole) {
+		return notFoundError()
+	}
+	return sendJSON(w, wormhole)
+}
+
+// List sends JSON of the wormholes leaving the place whose ID is given by the
+// "source" parameter, or of all wormholes with pagination if there is none.
+// Players only get wormholes their character knows about.
+func (h WormholeHandler) List(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	user, herr := loadSessionUser(db, r)
+	if herr != nil {
+		return herr
+	}
+	v, herr := loadVisibility(db, user)
+	if herr != nil {
+		return herr
+	}
+	if v != nil && !user.HasCharacter() {
+		return sendJSON(w, []*model.Wormhole{})
+	}
+	sourceStr := r.FormValue("source")
+	if len(sourceStr) == 0 {
+		var visibleTo int64
+		if v != nil {
+			visibleTo = user.Character.ID
+		}
+		start, count := listGetLimit(r)
+		wormholes, err := model.ListWormholes(db, start, count, visibleTo)
+		if err != nil {
+			return appError(err)
+		}
+		return sendJSON(w, wormholes)
+	}
+
+	sourceID, err := strconv.ParseInt(sourceStr, 10, 64)
+	if err != nil {
+		return userError(fmt.Errorf("Bad source place ID %q", sourceStr))
+	}
+	if v != nil && !v.CanSee(sourceID) {
+		return notFoundError()
+	}
+	source, err := model.LoadPlaceByID(db, sourceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFoundError()
+		}
+		return appError(err)
+	}
+	wormholes, err := model.LoadWormholes(db, source)
+	if err != nil {
+		return appError(err)
+	}
+	if v != nil {
+		wormholes = filterWormholes(v, wormholes)
+	}
+	return sendJSON(w, wormholes)
+}
+
+func filterWormholes(v model.Visibility, wormholes []*model.Wormhole) []*model.Wormhole {
+	visible := make([]*model.Wormhole, 0, len(wormholes))
+	for _, w := range wormholes {
+		if v.CanSeeWormhole(w) {
+			visible = append(visible, w)
+		}
+	}
+	return visible
+}
+
+// Create is not allowed, wormholes are created with the universe.
+func (h WormholeHandler) Create(db *sql.Tx, w http.ResponseWriter, r *http.Request) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Update is not allowed, wormholes are created with the universe.
+func (h WormholeHandler) Update(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
+
+// Delete is not allowed, wormholes are created with the universe.
+func (h WormholeHandler) Delete(db *sql.Tx, w http.ResponseWriter, r *http.Request, id string) *httpError {
+	return unknownMethodError(r.Method)
+}
@@ -0,0 +1,12 @@
+CREATE TABLE place_visibility (
+	turn_id INTEGER NOT NULL CONSTRAINT fk_vis_turn REFERENCES turns(id),
+	character_id INTEGER NOT NULL CONSTRAINT fk_vis_char REFERENCES characters(id),
+	place_id INTEGER NOT NULL CONSTRAINT fk_vis_place REFERENCES places(id),
+	visited BOOLEAN NOT NULL DEFAULT false,
+	CONSTRAINT pk_place_vis PRIMARY KEY (turn_id, character_id, place_id)
+);
+CREATE INDEX place_vis_char_idx ON place_visibility (character_id, turn_id);
+
+PRAGMA foreign_key_check;
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (4, strftime('%s', 'now'));
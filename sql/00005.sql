@@ -0,0 +1,8 @@
+CREATE TABLE universe_generations (
+	id INTEGER PRIMARY KEY NOT NULL,
+	seed INTEGER NOT NULL,
+	config_json TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (5, strftime('%s', 'now'));
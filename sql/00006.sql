@@ -0,0 +1,12 @@
+CREATE TABLE regions (
+	id INTEGER PRIMARY KEY NOT NULL,
+	name TEXT NOT NULL UNIQUE,
+	x INTEGER NOT NULL,
+	y INTEGER NOT NULL,
+	radius INTEGER NOT NULL
+);
+
+ALTER TABLE places ADD COLUMN region_id INTEGER DEFAULT NULL CONSTRAINT fk_place_region REFERENCES regions(id);
+CREATE INDEX place_region_idx ON places (region_id);
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (6, strftime('%s', 'now'));
@@ -0,0 +1,4 @@
+ALTER TABLE wormholes ADD COLUMN kind TEXT NOT NULL DEFAULT 'normal' CHECK (kind IN ('normal', 'jump', 'oneway'));
+ALTER TABLE wormholes ADD COLUMN cost_multiplier REAL NOT NULL DEFAULT 1.0;
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (7, strftime('%s', 'now'));
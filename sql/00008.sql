@@ -0,0 +1,12 @@
+CREATE TABLE sessions (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id INTEGER NOT NULL CONSTRAINT fk_session_user REFERENCES users(id) ON DELETE CASCADE,
+	created_at INTEGER NOT NULL,
+	last_seen_at INTEGER NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX session_user_idx ON sessions (user_id);
+CREATE INDEX session_created_idx ON sessions (created_at);
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (8, strftime('%s', 'now'));
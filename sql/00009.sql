@@ -0,0 +1,22 @@
+PRAGMA foreign_keys=OFF;
+
+CREATE TABLE new_wormholes (
+	id INTEGER PRIMARY KEY NOT NULL,
+	source_id INTEGER NOT NULL CONSTRAINT fk_wormh_source REFERENCES places(id),
+	destination_id INTEGER NOT NULL CONSTRAINT fk_wormh_dest REFERENCES places(id),
+	distance INTEGER NOT NULL,
+	kind TEXT NOT NULL DEFAULT 'normal' CHECK (kind IN ('normal', 'jump', 'oneway')),
+	cost_multiplier REAL NOT NULL DEFAULT 1.0,
+	CONSTRAINT uq_wormholes UNIQUE (source_id, destination_id)
+);
+INSERT INTO new_wormholes (id, source_id, destination_id, distance, kind, cost_multiplier)
+	SELECT rowid, source_id, destination_id, distance, kind, cost_multiplier
+	  FROM wormholes;
+
+DROP TABLE wormholes;
+ALTER TABLE new_wormholes RENAME TO wormholes;
+
+PRAGMA foreign_key_check;
+PRAGMA foreign_keys=ON;
+
+INSERT INTO mwk_schema_versions (num, deployed_at) VALUES (9, strftime('%s', 'now'));
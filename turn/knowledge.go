@@ -0,0 +1,151 @@
+package turn
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/config"
+	"github.com/morluque/moenawark/model"
+)
+
+// proficiency returns how well an entity knows a domain; 0 if it does not
+// know it at all.
+func proficiency(tx *sql.Tx, entityID, domainID int64) (int, error) {
+	var p int
+	row := tx.QueryRow(`
+	    SELECT coalesce(max(proficiency), 0)
+	      FROM knowledges
+	     WHERE entity_id = $1
+	       AND knowledge_domain_id = $2`, entityID, domainID)
+	err := row.Scan(&p)
+	return p, err
+}
+
+// improve raises the proficiency of an entity in a domain by one, if the
+// entity meets the constraints of the domain.
+func improve(tx *sql.Tx, entityID, domainID int64) error {
+	var missing int
+	row := tx.QueryRow(`
+	    SELECT count(*)
+	      FROM knowledge_constraints kc
+	     WHERE kc.target_domain_id = $1
+	       AND kc.minimum_proficiency > (SELECT coalesce(max(k.proficiency), 0)
+	                                       FROM knowledges k
+	                                      WHERE k.entity_id = $2
+	                                        AND k.knowledge_domain_id = kc.prerequisite_domain_id)`,
+		domainID, entityID)
+	if err := row.Scan(&missing); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("Entity %d lacks %d prerequisites of domain %d", entityID, missing, domainID)
+	}
+
+	result, err := tx.Exec(`
+	    UPDATE knowledges
+	       SET proficiency = proficiency + 1
+	     WHERE entity_id = $1
+	       AND knowledge_domain_id = $2`, entityID, domainID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO knowledges (entity_id, knowledge_domain_id, proficiency) VALUES ($1, $2, 1)",
+		entityID,
+		domainID)
+	return err
+}
+
+/*
+resolveInfluence raises the trust of an entity by the size of the influencing
+group. An entity controlled by no character comes under the control of the
+influencer's character once its trust reaches turn.recruit_trust.
+*/
+func resolveInfluence(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.InfluenceOrder)
+	_, err := tx.Exec(`
+	    UPDATE entities
+	       SET trust = trust + (SELECT group_count FROM entities WHERE resource_id = $1)
+	     WHERE resource_id = $2`, a.InfluencerID, a.TargetID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+	    UPDATE entities
+	       SET character_id = $1
+	     WHERE resource_id = $2
+	       AND character_id IS NULL
+	       AND trust >= $3`, o.CharacterID, a.TargetID, config.GetInt("turn.recruit_trust"))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Infof("Entity %d now follows character %d", a.TargetID, o.CharacterID)
+	}
+	return nil
+}
+
+// resolveTeach raises the proficiency of the student in a domain, as long as
+// the teacher knows it better.
+func resolveTeach(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.TeachOrder)
+	teacher, err := proficiency(tx, a.TeacherID, a.DomainID)
+	if err != nil {
+		return err
+	}
+	student, err := proficiency(tx, a.StudentID, a.DomainID)
+	if err != nil {
+		return err
+	}
+	if teacher <= student {
+		return fmt.Errorf("Entity %d knows no more of domain %d than entity %d", a.TeacherID, a.DomainID, a.StudentID)
+	}
+	return improve(tx, a.StudentID, a.DomainID)
+}
+
+// resolveLearn raises the proficiency of the student in the domain where the
+// teacher knows most more than it, among those it meets the constraints of.
+func resolveLearn(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.LearnOrder)
+	domains := make([]int64, 0)
+	rows, err := tx.Query(`
+	    SELECT t.knowledge_domain_id
+	      FROM knowledges t
+	 LEFT JOIN knowledges s
+	        ON s.entity_id = $1
+	       AND s.knowledge_domain_id = t.knowledge_domain_id
+	     WHERE t.entity_id = $2
+	       AND t.proficiency > coalesce(s.proficiency, 0)
+	  ORDER BY t.proficiency - coalesce(s.proficiency, 0) DESC, t.knowledge_domain_id`,
+		a.StudentID, a.TeacherID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		domains = append(domains, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, domainID := range domains {
+		if err := improve(tx, a.StudentID, domainID); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Entity %d can learn nothing from entity %d", a.StudentID, a.TeacherID)
+}
+
+// resolveExperiment raises the proficiency of an entity in a domain.
+func resolveExperiment(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.ExperimentOrder)
+	return improve(tx, a.StudentID, a.DomainID)
+}
@@ -0,0 +1,432 @@
+package turn
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+)
+
+func init() {
+	Register("name", resolveName)
+	Register("influence", resolveInfluence)
+	Register("teach", resolveTeach)
+	Register("learn", resolveLearn)
+	Register("experiment", resolveExperiment)
+	Register("separate", resolveSeparate)
+	Register("mix", resolveMix)
+	Register("split", resolveSplit)
+	Register("move", resolveMove)
+	Register("load", resolveLoad)
+	Register("unload", resolveUnload)
+	Register("attack", resolveAttack)
+	Register("build", resolveBuild)
+}
+
+func resolveName(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.NameOrder)
+	if _, err := tx.Exec("UPDATE entities SET name = $1 WHERE resource_id = $2", a.Name, a.SubjectID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE constructions SET name = $1 WHERE resource_id = $2", a.Name, a.SubjectID)
+	return err
+}
+
+// newObjectBeside creates an object at the place of the object of an ID, and
+// loads it in the same construction if that object is loaded.
+func newObjectBeside(tx *sql.Tx, id int64) (int64, error) {
+	result, err := tx.Exec("INSERT INTO objects (place_id) SELECT place_id FROM objects WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+	    INSERT INTO construction_freight (construction_id, object_id)
+	         SELECT construction_id, $1
+	           FROM construction_freight
+	          WHERE object_id = $2`, newID, id)
+	return newID, err
+}
+
+// addMatter adds some quantity of an atom to the components of a resource.
+func addMatter(tx *sql.Tx, resourceID, atomID int64, quantity int) error {
+	result, err := tx.Exec("INSERT INTO matters (quantity, atom_id) VALUES ($1, $2)", quantity, atomID)
+	if err != nil {
+		return err
+	}
+	matterID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO resource_components (resource_id, matter_id) VALUES ($1, $2)", resourceID, matterID)
+	return err
+}
+
+// copyResource makes object newID a resource made of the same matter as the
+// resource of ID id.
+func copyResource(tx *sql.Tx, id, newID int64) error {
+	_, err := tx.Exec(`
+	    INSERT INTO resources (object_id, volume, sturdiness)
+	         SELECT $1, volume, sturdiness
+	           FROM resources
+	          WHERE object_id = $2`, newID, id)
+	if err != nil {
+		return err
+	}
+	type matter struct {
+		atomID   int64
+		quantity int
+	}
+	matters := make([]matter, 0)
+	rows, err := tx.Query(`
+	    SELECT m.atom_id, m.quantity
+	      FROM resource_components rc,
+	           matters m
+	     WHERE rc.resource_id = $1
+	       AND rc.matter_id = m.id
+	  ORDER BY m.id`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m matter
+		if err := rows.Scan(&m.atomID, &m.quantity); err != nil {
+			return err
+		}
+		matters = append(matters, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range matters {
+		if err := addMatter(tx, newID, m.atomID, m.quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+resolveSeparate extracts matter from a resource into a new resource, at the
+same place and in the same construction as the original one. The volume of the
+new resource is the quantity of matter extracted, and is taken from the
+original resource.
+*/
+func resolveSeparate(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.SeparateOrder)
+	type matter struct {
+		id       int64
+		quantity int
+	}
+	matters := make([]matter, 0)
+	rows, err := tx.Query(`
+	    SELECT m.id, m.quantity
+	      FROM resource_components rc,
+	           matters m
+	     WHERE rc.resource_id = $1
+	       AND rc.matter_id = m.id
+	       AND m.atom_id = $2
+	  ORDER BY m.id`, a.SubjectID, a.AtomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m matter
+		if err := rows.Scan(&m.id, &m.quantity); err != nil {
+			return err
+		}
+		matters = append(matters, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	left := a.Quantity
+	for _, m := range matters {
+		if left <= 0 {
+			break
+		}
+		if m.quantity > left {
+			if _, err := tx.Exec("UPDATE matters SET quantity = quantity - $1 WHERE id = $2", left, m.id); err != nil {
+				return err
+			}
+			left = 0
+			break
+		}
+		if _, err := tx.Exec("DELETE FROM resource_components WHERE resource_id = $1 AND matter_id = $2", a.SubjectID, m.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM matters WHERE id = $1", m.id); err != nil {
+			return err
+		}
+		left -= m.quantity
+	}
+	if left > 0 {
+		return fmt.Errorf("Resource %d lacks %d of atom %d", a.SubjectID, left, a.AtomID)
+	}
+
+	id, err := newObjectBeside(tx, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO resources (object_id, volume, sturdiness) VALUES ($1, $2, 1)", id, a.Quantity)
+	if err != nil {
+		return err
+	}
+	if err := addMatter(tx, id, a.AtomID, a.Quantity); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE resources SET volume = max(1, volume - $1) WHERE object_id = $2", a.Quantity, a.SubjectID)
+	return err
+}
+
+// deleteObject removes an object that is neither an entity nor a
+// construction.
+func deleteObject(tx *sql.Tx, id int64) error {
+	if _, err := tx.Exec("DELETE FROM construction_freight WHERE object_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM resources WHERE object_id = $1", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM objects WHERE id = $1", id)
+	return err
+}
+
+// resolveMix merges the matter and volume of a resource into another one;
+// the mixed resource disappears.
+func resolveMix(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.MixOrder)
+	_, err := tx.Exec(`
+	    UPDATE resources
+	       SET volume = volume + (SELECT volume FROM resources WHERE object_id = $1)
+	     WHERE object_id = $2`, a.ObjectID, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE resource_components SET resource_id = $1 WHERE resource_id = $2",
+		a.SubjectID,
+		a.ObjectID)
+	if err != nil {
+		return err
+	}
+	return deleteObject(tx, a.ObjectID)
+}
+
+/*
+resolveSplit creates a new group of entities or constructions out of an
+existing one, with a copy of its body or structure, at the same place and in
+the same construction. Entities keep their controller and knowledge, and
+energy is shared in proportion to the size of each group. The freight of a
+construction stays in the original group.
+*/
+func resolveSplit(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.SplitOrder)
+	id, err := newObjectBeside(tx, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	if err := copyResource(tx, a.SubjectID, id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	    INSERT INTO entities (resource_id, character_id, group_count, name, trust, energy_level, energy_storage, energy_harvesting)
+	         SELECT $1, character_id, $2, name, trust, energy_level * $2 / group_count, energy_storage, energy_harvesting
+	           FROM entities
+	          WHERE resource_id = $3`, id, a.Quantity, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	    INSERT INTO knowledges (entity_id, knowledge_domain_id, proficiency)
+	         SELECT $1, knowledge_domain_id, proficiency
+	           FROM knowledges
+	          WHERE entity_id = $2`, id, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	    UPDATE entities
+	       SET group_count = group_count - $1,
+	           energy_level = energy_level - (SELECT energy_level FROM entities WHERE resource_id = $2)
+	     WHERE resource_id = $3`, a.Quantity, id, a.SubjectID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	    INSERT INTO constructions (resource_id, group_count, name, attack, movement, storage_volume, energy_level, energy_storage, energy_harvesting)
+	         SELECT $1, $2, name, attack, movement, storage_volume, energy_level * $2 / group_count, energy_storage, energy_harvesting
+	           FROM constructions
+	          WHERE resource_id = $3`, id, a.Quantity, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	    INSERT INTO construction_biocompatibility (construction_id, matter_id)
+	         SELECT $1, matter_id
+	           FROM construction_biocompatibility
+	          WHERE construction_id = $2`, id, a.SubjectID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	    UPDATE constructions
+	       SET group_count = group_count - $1,
+	           energy_level = energy_level - (SELECT energy_level FROM constructions WHERE resource_id = $2)
+	     WHERE resource_id = $3`, a.Quantity, id, a.SubjectID)
+	return err
+}
+
+// resolveMove moves a construction, along with its freight and everything
+// the freight carries in turn.
+func resolveMove(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.MoveOrder)
+	_, err := tx.Exec(`
+	    WITH RECURSIVE contents(id) AS (
+	        SELECT $1
+	         UNION
+	        SELECT f.object_id
+	          FROM construction_freight f,
+	               contents c
+	         WHERE f.construction_id = c.id)
+	    UPDATE objects
+	       SET place_id = $2
+	     WHERE id IN (SELECT id FROM contents)`,
+		a.SubjectID, a.DestinationID)
+	return err
+}
+
+// resolveLoad loads a freight into a construction, as long as the volume of
+// everything the construction holds fits in its storage volume.
+func resolveLoad(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.LoadOrder)
+	var storage, used, volume int
+	row := tx.QueryRow(`
+	    SELECT c.storage_volume,
+	           (SELECT coalesce(sum(r.volume), 0)
+	              FROM construction_freight f,
+	                   resources r
+	             WHERE f.construction_id = c.resource_id
+	               AND r.object_id = f.object_id),
+	           (SELECT coalesce(sum(volume), 0) FROM resources WHERE object_id = $1)
+	      FROM constructions c
+	     WHERE c.resource_id = $2`, a.FreightID, a.ContainerID)
+	if err := row.Scan(&storage, &used, &volume); err != nil {
+		return err
+	}
+	if used+volume > storage {
+		return fmt.Errorf(
+			"Construction %d has %d volume left, can't load object %d of volume %d",
+			a.ContainerID, storage-used, a.FreightID, volume)
+	}
+	_, err := tx.Exec(
+		"INSERT INTO construction_freight (construction_id, object_id) VALUES ($1, $2)",
+		a.ContainerID,
+		a.FreightID)
+	return err
+}
+
+// resolveUnload leaves a freight at the place of the construction holding it.
+func resolveUnload(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.UnloadOrder)
+	_, err := tx.Exec(
+		"DELETE FROM construction_freight WHERE construction_id = $1 AND object_id = $2",
+		a.ContainerID,
+		a.FreightID)
+	return err
+}
+
+/*
+resolveAttack makes a group of entities attack a group of entities or
+constructions. Each attacking entity deals one point of damage, and the
+defending group loses one member for each sturdiness points of damage. When no
+member is left, the entity or construction is destroyed, leaving its body or
+structure as a plain resource; the freight of a destroyed construction stays
+at its place.
+*/
+func resolveAttack(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.AttackOrder)
+	var strength, count, sturdiness uint
+	row := tx.QueryRow("SELECT group_count FROM entities WHERE resource_id = $1", a.AttackerID)
+	if err := row.Scan(&strength); err != nil {
+		return err
+	}
+	row = tx.QueryRow(`
+	    SELECT g.group_count, r.sturdiness
+	      FROM (SELECT resource_id, group_count FROM entities
+	             UNION ALL
+	            SELECT resource_id, group_count FROM constructions) g,
+	           resources r
+	     WHERE r.object_id = g.resource_id
+	       AND g.resource_id = $1`, a.DefenderID)
+	if err := row.Scan(&count, &sturdiness); err != nil {
+		return err
+	}
+
+	losses := strength / sturdiness
+	if losses < count {
+		log.Infof("Entity %d killed %d out of %d of group %d", a.AttackerID, losses, count, a.DefenderID)
+		if _, err := tx.Exec("UPDATE entities SET group_count = group_count - $1 WHERE resource_id = $2", losses, a.DefenderID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE constructions SET group_count = group_count - $1 WHERE resource_id = $2", losses, a.DefenderID)
+		return err
+	}
+
+	log.Infof("Entity %d destroyed group %d", a.AttackerID, a.DefenderID)
+	for _, query := range []string{
+		"DELETE FROM knowledges WHERE entity_id = $1",
+		"DELETE FROM entities WHERE resource_id = $1",
+		"DELETE FROM construction_freight WHERE construction_id = $1",
+		"DELETE FROM construction_biocompatibility WHERE construction_id = $1",
+		"DELETE FROM constructions WHERE resource_id = $1",
+	} {
+		if _, err := tx.Exec(query, a.DefenderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveBuild turns a resource into a construction, using energy of the
+// builder.
+func resolveBuild(tx *sql.Tx, o *model.Order, args model.OrderArgs) error {
+	a := args.(*model.BuildOrder)
+	var energy int
+	row := tx.QueryRow("SELECT energy_level FROM entities WHERE resource_id = $1", a.BuilderID)
+	if err := row.Scan(&energy); err != nil {
+		return err
+	}
+	if energy < a.EnergyBuild {
+		return fmt.Errorf("Entity %d has only %d energy, %d needed to build", a.BuilderID, energy, a.EnergyBuild)
+	}
+	_, err := tx.Exec(
+		"UPDATE entities SET energy_level = energy_level - $1 WHERE resource_id = $2",
+		a.EnergyBuild,
+		a.BuilderID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE resources SET sturdiness = $1 WHERE object_id = $2", a.Sturdiness, a.BomID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	    INSERT INTO constructions (resource_id, name, attack, movement, storage_volume, energy_storage, energy_harvesting)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		a.BomID,
+		fmt.Sprintf("Construction %d", a.BomID),
+		a.Attack,
+		a.Movement,
+		a.StorageVolume,
+		a.EnergyStorage,
+		a.EnergyHarvesting)
+	return err
+}
@@ -0,0 +1,138 @@
+/*
+Package turn implements the resolution of game turns for Moenawark.
+
+At the end of each turn, all orders given by characters are resolved in the
+order they were given, then a new turn is opened.
+*/
+package turn
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/config"
+	"github.com/morluque/moenawark/loglevel"
+	"github.com/morluque/moenawark/model"
+	"sync"
+)
+
+// Resolver applies the effects of an order to the model; args are the
+// decoded arguments of the order.
+type Resolver func(tx *sql.Tx, o *model.Order, args model.OrderArgs) error
+
+var (
+	log          *loglevel.Logger
+	resolvers    = make(map[string]Resolver)
+	resolverLock = sync.RWMutex{}
+)
+
+func init() {
+	log = loglevel.New("turn", loglevel.Debug)
+}
+
+// ReloadConfig performs required actions to reload all dynamic config.
+func ReloadConfig() {
+	log.SetLevelName(config.Get("loglevel.turn"))
+}
+
+// Register associates a resolver with an order type; any previous resolver
+// for that type is replaced.
+func Register(orderType string, r Resolver) {
+	resolverLock.Lock()
+	defer resolverLock.Unlock()
+	resolvers[orderType] = r
+}
+
+func getResolver(orderType string) (Resolver, bool) {
+	resolverLock.RLock()
+	defer resolverLock.RUnlock()
+	r, ok := resolvers[orderType]
+	return r, ok
+}
+
+/*
+Resolve ends the current turn and opens the next one.
+
+Every order of the current turn is validated again, since the universe may
+have changed since it was given, then dispatched to the resolver registered
+for its type, and its cost is taken from the actions of its character. Each
+order is resolved inside its own savepoint, so that a failing order is
+discarded without preventing the others to be applied, nor spending actions.
+Characters then get their actions back, and visibility of the universe is
+computed for the new turn. If no turn is open yet, the first one is opened.
+*/
+func Resolve(tx *sql.Tx) (*model.Turn, error) {
+	current, err := model.LoadCurrentTurn(tx)
+	if err == sql.ErrNoRows {
+		log.Infof("No turn open yet, opening first turn")
+		return openTurn(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Resolving turn %d", current.ID)
+	if err = current.Close(tx); err != nil {
+		return nil, err
+	}
+	orders, err := model.LoadOrders(tx, current.ID)
+	if err != nil {
+		return nil, err
+	}
+	failed := 0
+	for _, o := range orders {
+		if err := resolveOrder(tx, o); err != nil {
+			failed++
+			log.Warnf("order %d (%s) of character %d failed: %s", o.ID, o.Type, o.CharacterID, err.Error())
+		}
+	}
+	log.Infof("Resolved %d orders of turn %d, %d failed", len(orders), current.ID, failed)
+
+	return openTurn(tx)
+}
+
+// openTurn opens a new turn, gives characters their actions for the turn
+// and computes what they know of the universe at its start.
+func openTurn(tx *sql.Tx) (*model.Turn, error) {
+	next, err := model.OpenTurn(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err = model.ResetActions(tx, uint(config.GetInt("turn.actions_per_turn"))); err != nil {
+		return nil, err
+	}
+	err = model.UpdateVisibility(tx, next.ID, config.GetInt("visibility.sense_hops"))
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Turn %d opened", next.ID)
+	return next, nil
+}
+
+func resolveOrder(tx *sql.Tx, o *model.Order) error {
+	r, ok := getResolver(o.Type)
+	if !ok {
+		return fmt.Errorf("no resolver for order type %s", o.Type)
+	}
+	args, err := o.Args(tx)
+	if err != nil {
+		return err
+	}
+
+	savepoint := fmt.Sprintf("order_%d", o.ID)
+	if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+	err = model.SpendActions(tx, o.CharacterID, o.Cost)
+	if err == nil {
+		err = r(tx, o, args)
+	}
+	if err != nil {
+		if _, rerr := tx.Exec("ROLLBACK TO " + savepoint); rerr != nil {
+			return rerr
+		}
+		tx.Exec("RELEASE " + savepoint)
+		return err
+	}
+	_, err = tx.Exec("RELEASE " + savepoint)
+	return err
+}
@@ -0,0 +1,135 @@
+package universe
+
+import (
+	"github.com/morluque/moenawark/model"
+	"math"
+)
+
+/*
+AnomalyConfig holds configuration for special wormholes, added once ways are
+generated.
+
+A CostFraction of wormholes get a cost multiplier between 1 and
+MaxCostMultiplier. A OneWayFraction of wormholes become one-way, as long as
+every place can still be reached from every other one. Finally, JumpCount
+long-range jumps link places at least JumpMinLength apart, crossing other
+wormholes; traversing them costs JumpCostMultiplier times their length.
+*/
+type AnomalyConfig struct {
+	CostFraction       float64 `json:"cost_fraction"`
+	MaxCostMultiplier  float64 `json:"max_cost_multiplier"`
+	OneWayFraction     float64 `json:"oneway_fraction"`
+	JumpCount          int     `json:"jump_count"`
+	JumpMinLength      float64 `json:"jump_min_length"`
+	JumpCostMultiplier float64 `json:"jump_cost_multiplier"`
+}
+
+// addAnomalies turns some wormholes into special ones, and adds jumps.
+func (u *Universe) addAnomalies() {
+	cfg := u.Anomalies
+	if cfg.CostFraction > 0 && cfg.MaxCostMultiplier > 1 {
+		n := 0
+		for _, w := range u.Wormholes {
+			if u.rnd.Float64() < cfg.CostFraction {
+				m := 1 + u.rnd.Float64()*(cfg.MaxCostMultiplier-1)
+				w.CostMultiplier = math.Round(m*10) / 10
+				n++
+			}
+		}
+		log.Infof("%d costly wormholes", n)
+	}
+	if cfg.OneWayFraction > 0 {
+		u.addOneWayWormholes(cfg.OneWayFraction)
+	}
+	if cfg.JumpCount > 0 {
+		u.addJumps(cfg.JumpCount, cfg.JumpMinLength, cfg.JumpCostMultiplier)
+	}
+}
+
+/*
+addOneWayWormholes makes a fraction of wormholes one-way, in a random
+direction.
+
+A wormhole from a to b is only made one-way if b can still reach a without
+it, so that every place stays reachable from every other one.
+*/
+func (u *Universe) addOneWayWormholes(fraction float64) {
+	ends := make(map[int64][]*model.Wormhole)
+	for _, w := range u.Wormholes {
+		ends[w.Source.ID] = append(ends[w.Source.ID], w)
+		ends[w.Destination.ID] = append(ends[w.Destination.ID], w)
+	}
+	n := 0
+	for _, i := range u.rnd.Perm(len(u.Wormholes)) {
+		w := u.Wormholes[i]
+		if u.rnd.Float64() >= fraction || w.Kind != model.WormholeNormal {
+			continue
+		}
+		if u.rnd.Intn(2) == 1 {
+			w.Source, w.Destination = w.Destination, w.Source
+		}
+		w.Kind = model.WormholeOneWay
+		if reaches(ends, w.Destination.ID, w.Source.ID) {
+			n++
+		} else {
+			w.Kind = model.WormholeNormal
+		}
+	}
+	log.Infof("%d one-way wormholes", n)
+}
+
+// reaches returns true if place to can be reached from place from, following
+// wormholes in a direction they can be traversed.
+func reaches(ends map[int64][]*model.Wormhole, from, to int64) bool {
+	seen := map[int64]bool{from: true}
+	queue := []int64{from}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == to {
+			return true
+		}
+		for _, w := range ends[id] {
+			next := w.Destination.ID
+			if next == id {
+				if !w.Reversible() {
+					continue
+				}
+				next = w.Source.ID
+			}
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
+
+// addJumps adds long-range jumps between random places at least minLength
+// apart and not already linked.
+func (u *Universe) addJumps(count int, minLength, costMultiplier float64) {
+	linked := make(map[[2]int64]bool)
+	for _, w := range u.Wormholes {
+		linked[[2]int64{w.Source.ID, w.Destination.ID}] = true
+		linked[[2]int64{w.Destination.ID, w.Source.ID}] = true
+	}
+	n := 0
+	for try := 0; n < count && try < count*1000 && len(u.Places) > 1; try++ {
+		src, dst := u.Places[u.rnd.Intn(len(u.Places))], u.Places[u.rnd.Intn(len(u.Places))]
+		length := dist(placePoint(src), placePoint(dst))
+		if src.ID == dst.ID || length < minLength || linked[[2]int64{src.ID, dst.ID}] {
+			continue
+		}
+		w := model.NewWormhole(src, dst, int(length))
+		w.Kind = model.WormholeJump
+		if costMultiplier > 0 {
+			w.CostMultiplier = costMultiplier
+		}
+		u.Wormholes = append(u.Wormholes, w)
+		linked[[2]int64{src.ID, dst.ID}], linked[[2]int64{dst.ID, src.ID}] = true, true
+		n++
+		log.Debugf("Jump from %s to %s, length %d", src.Name, dst.Name, w.Distance)
+	}
+	log.Infof("%d jumps", n)
+}
@@ -0,0 +1,176 @@
+package universe
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ConnectivityReport tells how connected the universe was after generating
+// ways, and how it was repaired.
+type ConnectivityReport struct {
+	// ComponentSizes holds the number of places of each connected component
+	// before repair, largest first.
+	ComponentSizes []int `json:"component_sizes"`
+	// RepairWays is the number of ways added to join components.
+	RepairWays int `json:"repair_ways"`
+	// Components is the number of connected components after repair; it is
+	// 1 unless the repair failed.
+	Components int `json:"components"`
+}
+
+// pointSet is a union-find structure over points.
+type pointSet struct {
+	parent map[point]point
+	size   map[point]int
+}
+
+func newPointSet(points []point) *pointSet {
+	ps := &pointSet{parent: make(map[point]point), size: make(map[point]int)}
+	for _, p := range points {
+		ps.parent[p] = p
+		ps.size[p] = 1
+	}
+	return ps
+}
+
+func (ps *pointSet) find(p point) point {
+	for !ps.parent[p].equal(p) {
+		ps.parent[p] = ps.parent[ps.parent[p]]
+		p = ps.parent[p]
+	}
+	return p
+}
+
+func (ps *pointSet) union(a, b point) bool {
+	ra, rb := ps.find(a), ps.find(b)
+	if ra.equal(rb) {
+		return false
+	}
+	if ps.size[ra] < ps.size[rb] {
+		ra, rb = rb, ra
+	}
+	ps.parent[rb] = ra
+	ps.size[ra] += ps.size[rb]
+	return true
+}
+
+func (ps *pointSet) componentSizes() []int {
+	sizes := make([]int, 0)
+	for p, parent := range ps.parent {
+		if p.equal(parent) {
+			sizes = append(sizes, ps.size[p])
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes
+}
+
+func (u *Universe) allPoints() []point {
+	points := append([]point{}, u.Region.points...)
+	for _, r := range u.Regions {
+		points = append(points, r.points...)
+	}
+	return points
+}
+
+func (u *Universe) allSegments() []segment {
+	segments := append([]segment{}, u.Region.segments...)
+	for _, r := range u.Regions {
+		segments = append(segments, r.segments...)
+	}
+	return segments
+}
+
+/*
+connect makes sure every place can be reached from any other one.
+
+Connected components are computed from the generated ways; then, as long as
+there is more than one component, the shortest way joining two components
+without crossing any other way is added. It fails if components can't be
+joined.
+
+Candidate ways are searched among places close to each other first, doubling
+the search distance until components are joined or every pair of places was
+tried.
+*/
+func (u *Universe) connect() (*ConnectivityReport, error) {
+	points := u.allPoints()
+	segments := u.allSegments()
+	ps := newPointSet(points)
+	for _, s := range segments {
+		ps.union(s.a, s.b)
+	}
+	report := &ConnectivityReport{ComponentSizes: ps.componentSizes()}
+	report.Components = len(report.ComponentSizes)
+	log.Infof("%d connected components before repair: %v", report.Components, report.ComponentSizes)
+	if report.Components <= 1 {
+		return report, nil
+	}
+
+	index := newGrid(u.MaxWayLength)
+	index.addPoints(points...)
+	index.addSegments(segments...)
+	maxDist := diagonal(points)
+	for d := math.Max(u.MaxWayLength, 1); report.Components > 1; d *= 2 {
+		for _, s := range repairCandidates(ps, index, points, d) {
+			if report.Components <= 1 {
+				break
+			}
+			if ps.find(s.a).equal(ps.find(s.b)) || index.intersect(s) {
+				continue
+			}
+			ps.union(s.a, s.b)
+			index.addSegments(s)
+			u.Region.segments = append(u.Region.segments, s)
+			report.RepairWays++
+			report.Components--
+		}
+		if d >= maxDist {
+			break
+		}
+	}
+	log.Infof("Added %d ways to join components, %d component(s) left", report.RepairWays, report.Components)
+	if report.Components > 1 {
+		return report, fmt.Errorf("universe is not connected: %d components can't be joined without crossing ways", report.Components)
+	}
+	return report, nil
+}
+
+// repairCandidates returns the segments at most maxDist long between points
+// of different components, shortest first.
+func repairCandidates(ps *pointSet, index *grid, points []point, maxDist float64) []segment {
+	candidates := make([]segment, 0)
+	dists := make(map[segment]float64)
+	for _, a := range points {
+		for _, b := range index.neighbours(a, maxDist) {
+			if !a.less(b) || ps.find(a).equal(ps.find(b)) {
+				continue
+			}
+			s := newSegment(a, b)
+			candidates = append(candidates, s)
+			dists[s] = dist(a, b)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		di, dj := dists[candidates[i]], dists[candidates[j]]
+		if di != dj {
+			return di < dj
+		}
+		return candidates[i].less(candidates[j])
+	})
+	return candidates
+}
+
+// diagonal returns the length of the diagonal of the bounding box of points.
+func diagonal(points []point) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+	min, max := points[0], points[0]
+	for _, p := range points {
+		min = point{x: math.Min(min.x, p.x), y: math.Min(min.y, p.y)}
+		max = point{x: math.Max(max.x, p.x), y: math.Max(max.y, p.y)}
+	}
+	return dist(min, max)
+}
@@ -0,0 +1,66 @@
+package universe
+
+import (
+	"github.com/morluque/moenawark/model"
+	"math"
+)
+
+/*
+EnergyConfig holds configuration for the energy production of places.
+
+Production follows a normal distribution, whose parameters differ inside and
+outside of regions. The mean is raised by up to CoreBonus near the center of
+the universe, decreasing linearly to nothing at its rim. Finally, HubCount
+places picked at random are high-yield hubs whose production is multiplied by
+HubMultiplier.
+*/
+type EnergyConfig struct {
+	Mean          float64 `json:"mean"`
+	StdDev        float64 `json:"stddev"`
+	RegionMean    float64 `json:"region_mean"`
+	RegionStdDev  float64 `json:"region_stddev"`
+	CoreBonus     float64 `json:"core_bonus"`
+	HubCount      int     `json:"hub_count"`
+	HubMultiplier float64 `json:"hub_multiplier"`
+}
+
+// energyProduction draws the energy production of a place.
+func (u *Universe) energyProduction(p *model.Place) int {
+	cfg := u.Energy
+	mean, stddev := cfg.Mean, cfg.StdDev
+	if p.RegionID > 0 {
+		mean, stddev = cfg.RegionMean, cfg.RegionStdDev
+	}
+	if u.Radius > 0 {
+		d := dist(u.Region.Center, point{x: float64(p.X), y: float64(p.Y)})
+		mean += cfg.CoreBonus * math.Max(0, 1-d/u.Radius)
+	}
+	e := math.Round(mean + u.rnd.NormFloat64()*stddev)
+	return int(math.Max(0, e))
+}
+
+// assignEnergy sets the energy production of all places.
+func (u *Universe) assignEnergy() {
+	for _, p := range u.Places {
+		p.EnergyProduction = u.energyProduction(p)
+	}
+	if u.Energy.HubCount > 0 && u.Energy.HubMultiplier > 0 {
+		for _, i := range u.rnd.Perm(len(u.Places))[:minInt(u.Energy.HubCount, len(u.Places))] {
+			p := u.Places[i]
+			p.EnergyProduction = int(math.Round(float64(p.EnergyProduction) * u.Energy.HubMultiplier))
+			log.Debugf("Place %s is an energy hub producing %d", p.Name, p.EnergyProduction)
+		}
+	}
+	total := 0
+	for _, p := range u.Places {
+		total += p.EnergyProduction
+	}
+	log.Infof("Total energy production of %d places is %d", len(u.Places), total)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,195 @@
+package universe
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/morluque/moenawark/markov"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+Expand grows the universe saved in database with new regions.
+
+New regions are placed in unused space, around or inside the current universe,
+and filled with places whose names don't collide with existing ones. Places
+of new regions are linked to each other and to nearby existing places, and
+the graph is kept connected, without any wormhole crossing another one.
+
+The same universe, configuration and seed always yield the same expansion;
+a zero seed is picked from the current time.
+*/
+func Expand(tx *sql.Tx, rc RegionConfig, markovGen *markov.NameGenerator, seed int64) (*Universe, error) {
+	u, err := Load(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Places) == 0 {
+		return nil, mwkerr.New(mwkerr.DatabaseEmpty, "No universe to expand, generate one first")
+	}
+	if err := checkCorpora(rc.Corpora); err != nil {
+		return nil, err
+	}
+	u.Seed = seed
+	if u.Seed == 0 {
+		u.Seed = time.Now().UnixNano()
+	}
+	u.rnd = rand.New(rand.NewSource(u.Seed))
+	u.MarkovGen = markovGen
+	u.MarkovGen.Seed(u.Seed)
+	if u.Radius <= 0 {
+		u.Region = u.boundingRegion()
+	}
+
+	existingPoints := u.allPoints()
+	existingSegments := len(u.Region.segments)
+	existingRegions := len(u.Regions)
+	if err := u.placeNewRegions(rc); err != nil {
+		return nil, err
+	}
+	newRegions := u.Regions[existingRegions:]
+
+	points := existingPoints
+	segments := u.allSegments()
+	for _, r := range newRegions {
+		r.generatePoints(rc.MinPlaceDist, points, u.rnd)
+		for i, p := range r.points {
+			r.points[i] = point{x: math.Trunc(p.x), y: math.Trunc(p.y)}
+		}
+		r.segments = u.linkSegments(r.points, nil, rc.MaxWayLength, segments)
+		points = append(points, r.points...)
+		segments = append(segments, r.segments...)
+	}
+	newPoints := make([]point, 0)
+	for _, r := range newRegions {
+		newPoints = append(newPoints, r.points...)
+	}
+	// Only keep ways between new and existing places: ways between new
+	// places were already generated inside their region.
+	existing := make(map[point]bool)
+	for _, p := range existingPoints {
+		existing[p] = true
+	}
+	links := filterSegments(u.linkSegments(newPoints, existingPoints, u.MaxWayLength, segments), func(s segment) bool {
+		return existing[s.a] != existing[s.b]
+	})
+	u.Region.segments = append(u.Region.segments, links...)
+
+	report, err := u.connect()
+	u.Connectivity = report
+	if err != nil {
+		return u, err
+	}
+
+	if err := u.saveExpansion(tx, rc, newRegions, u.Region.segments[existingSegments:]); err != nil {
+		return u, err
+	}
+	return Load(tx)
+}
+
+// boundingRegion returns the smallest region centered on the places that
+// contains all of them.
+func (u *Universe) boundingRegion() *Region {
+	points := u.allPoints()
+	min, max := points[0], points[0]
+	for _, p := range points {
+		min = point{x: math.Min(min.x, p.x), y: math.Min(min.y, p.y)}
+		max = point{x: math.Max(max.x, p.x), y: math.Max(max.y, p.y)}
+	}
+	center := point{x: (min.x + max.x) / 2, y: (min.y + max.y) / 2}
+	r := newRegion(center, dist(min, max)/2)
+	r.points, r.segments = u.Region.points, u.Region.segments
+	return r
+}
+
+/*
+placeNewRegions picks centers of new regions at random, so that regions
+don't overlap and don't contain existing places. Centers may lie up to two
+region radiuses beyond the rim of the universe, so that regions can open new
+frontiers.
+*/
+func (u *Universe) placeNewRegions(rc RegionConfig) error {
+	points := u.allPoints()
+	index := newGrid(rc.Radius)
+	index.addPoints(points...)
+	area := newRegion(u.Region.Center, u.Region.Radius+2*rc.Radius)
+	for i := 0; i < rc.Count; i++ {
+		placed := false
+		for try := 0; try < 10000 && !placed; try++ {
+			c := randPointInRegion(area, u.rnd)
+			if !index.farEnough(c, rc.Radius+rc.MinPlaceDist) {
+				continue
+			}
+			overlap := false
+			for _, r := range u.Regions {
+				if dist(r.Center, c) <= r.Radius+rc.Radius {
+					overlap = true
+					break
+				}
+			}
+			if overlap {
+				continue
+			}
+			u.Regions = append(u.Regions, newRegion(c, rc.Radius))
+			placed = true
+			log.Infof("new region [%f, %f] r%f\n", c.x, c.y, rc.Radius)
+		}
+		if !placed {
+			return fmt.Errorf("no unused space left for %d new region(s) of radius %d", rc.Count-i, int(rc.Radius))
+		}
+	}
+	return nil
+}
+
+// saveExpansion stores new regions, their places and new wormholes in
+// database, along with a universe generation record.
+func (u *Universe) saveExpansion(tx *sql.Tx, rc RegionConfig, regions []*Region, links []segment) error {
+	places := make([]*model.Place, 0)
+	for _, r := range regions {
+		if err := u.pickCorpus(r, rc.Corpora); err != nil {
+			return err
+		}
+		name, err := newName(r.nameGen, u.names)
+		if err != nil {
+			return err
+		}
+		r.Name = name
+		m := model.NewRegion(r.Name, int(r.Center.x), int(r.Center.y), int(r.Radius))
+		if err := m.Save(tx); err != nil {
+			return err
+		}
+		r.ID = m.ID
+		for _, pt := range r.points {
+			p, err := placeFromPoint(pt, r.nameGen, u.names)
+			if err != nil {
+				return err
+			}
+			p.RegionID = r.ID
+			p.EnergyProduction = u.energyProduction(p)
+			if err := p.Save(tx); err != nil {
+				return err
+			}
+			places = append(places, p)
+		}
+	}
+	u.Places = append(u.Places, places...)
+	log.Infof("Saved %d new regions and %d new places to database\n", len(regions), len(places))
+
+	segments := append([]segment{}, links...)
+	for _, r := range regions {
+		segments = append(segments, r.segments...)
+	}
+	index := placesByPoint(u.Places)
+	for _, s := range segments {
+		if err := wormholeFromSegment(s, index).Save(tx); err != nil {
+			return err
+		}
+	}
+	log.Infof("Saved %d new wormholes to database\n", len(segments))
+
+	u.RegionConfig = rc
+	return u.saveGeneration(tx)
+}
@@ -0,0 +1,315 @@
+package universe
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
+	"io"
+	"math"
+)
+
+// ExportVersion is the version of the universe export format; it changes
+// whenever the format changes in an incompatible way.
+const ExportVersion = 1
+
+/*
+Export is a complete description of a universe, meant to be saved as JSON,
+edited by hand and imported in another database.
+
+Places and regions are designated by their ID inside the document; IDs are
+reassigned on import.
+*/
+type Export struct {
+	Version   int               `json:"version"`
+	Config    Config            `json:"config"`
+	Regions   []*model.Region   `json:"regions"`
+	Places    []*model.Place    `json:"places"`
+	Wormholes []*ExportWormhole `json:"wormholes"`
+}
+
+// ExportWormhole is a wormhole in an universe export, linking two places by
+// their ID.
+type ExportWormhole struct {
+	SourceID      int64 `json:"source_id"`
+	DestinationID int64 `json:"destination_id"`
+	// Distance is computed from place coordinates if not positive.
+	Distance int `json:"distance"`
+	// Kind is one of the model.Wormhole* kinds, normal if empty; only
+	// jumps may cross other wormholes.
+	Kind           string  `json:"kind,omitempty"`
+	CostMultiplier float64 `json:"cost_multiplier,omitempty"`
+}
+
+// Export describes the universe in the export format.
+func (u *Universe) Export() *Export {
+	e := &Export{
+		Version:   ExportVersion,
+		Config:    u.Config,
+		Regions:   make([]*model.Region, 0, len(u.Regions)),
+		Places:    u.Places,
+		Wormholes: make([]*ExportWormhole, 0, len(u.Wormholes)),
+	}
+	for _, r := range u.Regions {
+		e.Regions = append(e.Regions, model.NewRegion(r.Name, int(r.Center.x), int(r.Center.y), int(r.Radius)))
+		e.Regions[len(e.Regions)-1].ID = r.ID
+	}
+	for _, w := range u.Wormholes {
+		e.Wormholes = append(e.Wormholes, &ExportWormhole{
+			SourceID:       w.Source.ID,
+			DestinationID:  w.Destination.ID,
+			Distance:       w.Distance,
+			Kind:           w.Kind,
+			CostMultiplier: w.CostMultiplier,
+		})
+	}
+	return e
+}
+
+// WriteJSON writes the universe in the export format.
+func (u *Universe) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(u.Export())
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+/*
+WriteGeoJSON writes the universe as a GeoJSON FeatureCollection, for use in
+map tools; coordinates are universe coordinates, not longitudes and
+latitudes.
+
+Regions are points with a radius property, places are points and wormholes
+are lines; the "kind" property of each feature tells which it is. GeoJSON
+documents can't be imported back, use WriteJSON for that.
+*/
+func (u *Universe) WriteGeoJSON(w io.Writer) error {
+	features := make([]geoJSONFeature, 0, len(u.Regions)+len(u.Places)+len(u.Wormholes))
+	for _, r := range u.Regions {
+		features = append(features, geoJSONFeature{
+			Type:     "Feature",
+			ID:       fmt.Sprintf("region-%d", r.ID),
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: []float64{r.Center.x, r.Center.y}},
+			Properties: map[string]interface{}{
+				"kind":   "region",
+				"name":   r.Name,
+				"radius": r.Radius,
+			},
+		})
+	}
+	for _, p := range u.Places {
+		features = append(features, geoJSONFeature{
+			Type:     "Feature",
+			ID:       fmt.Sprintf("place-%d", p.ID),
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: []int{p.X, p.Y}},
+			Properties: map[string]interface{}{
+				"kind":              "place",
+				"name":              p.Name,
+				"energy_production": p.EnergyProduction,
+				"region_id":         p.RegionID,
+			},
+		})
+	}
+	for _, wh := range u.Wormholes {
+		features = append(features, geoJSONFeature{
+			Type: "Feature",
+			ID:   fmt.Sprintf("wormhole-%d", wh.ID),
+			Geometry: geoJSONGeometry{
+				Type:        "LineString",
+				Coordinates: [][]int{{wh.Source.X, wh.Source.Y}, {wh.Destination.X, wh.Destination.Y}},
+			},
+			Properties: map[string]interface{}{
+				"kind":            "wormhole",
+				"source_id":       wh.Source.ID,
+				"destination_id":  wh.Destination.ID,
+				"distance":        wh.Distance,
+				"wormhole_kind":   wh.Kind,
+				"cost_multiplier": wh.CostMultiplier,
+			},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"type":        "FeatureCollection",
+		"mwk_version": ExportVersion,
+		"seed":        u.Seed,
+		"features":    features,
+	})
+}
+
+/*
+Import reads a universe in the export format and saves it to an empty
+database.
+
+The document is checked before anything is saved: names and positions of
+places must be unique, wormholes must link existing places and must not cross
+each other, unless they are jumps.
+*/
+func Import(tx *sql.Tx, r io.Reader) (*Universe, error) {
+	e := &Export{}
+	if err := json.NewDecoder(r).Decode(e); err != nil {
+		return nil, mwkerr.New(mwkerr.InvalidUniverse, "Can't decode universe: %s", err.Error())
+	}
+	if e.Version != ExportVersion {
+		return nil, mwkerr.New(mwkerr.InvalidUniverse, "Unsupported universe format version %d", e.Version)
+	}
+	n, err := model.CountPlaces(tx)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, mwkerr.New(mwkerr.DatabaseAlreadyInitialized, "Universe already generated (%d places)", n)
+	}
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+
+	regionIDs := make(map[int64]int64)
+	for _, r := range e.Regions {
+		oldID := r.ID
+		r.ID = 0
+		if err := r.Save(tx); err != nil {
+			return nil, err
+		}
+		regionIDs[oldID] = r.ID
+	}
+	places := make(map[int64]*model.Place)
+	for _, p := range e.Places {
+		oldID := p.ID
+		p.ID = 0
+		p.RegionID = regionIDs[p.RegionID]
+		if err := p.Save(tx); err != nil {
+			return nil, err
+		}
+		places[oldID] = p
+	}
+	for _, ew := range e.Wormholes {
+		src, dst := places[ew.SourceID], places[ew.DestinationID]
+		distance := ew.Distance
+		if distance <= 0 {
+			distance = int(dist(placePoint(src), placePoint(dst)))
+		}
+		w := model.NewWormhole(src, dst, distance)
+		if len(ew.Kind) > 0 {
+			w.Kind = ew.Kind
+		}
+		if ew.CostMultiplier > 0 {
+			w.CostMultiplier = ew.CostMultiplier
+		}
+		if err := w.Save(tx); err != nil {
+			return nil, err
+		}
+	}
+	u := &Universe{Config: e.Config}
+	if err := u.saveGeneration(tx); err != nil {
+		return nil, err
+	}
+	log.Infof("Imported %d regions, %d places and %d wormholes", len(e.Regions), len(e.Places), len(e.Wormholes))
+
+	return Load(tx)
+}
+
+func placePoint(p *model.Place) point {
+	return point{x: float64(p.X), y: float64(p.Y)}
+}
+
+func (e *Export) validate() error {
+	regions := make(map[int64]bool)
+	regionNames := make(map[string]bool)
+	for _, r := range e.Regions {
+		if regions[r.ID] {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Duplicate region ID %d", r.ID)
+		}
+		if len(r.Name) == 0 || regionNames[r.Name] {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Empty or duplicate region name %q", r.Name)
+		}
+		regions[r.ID], regionNames[r.Name] = true, true
+	}
+
+	places := make(map[int64]*model.Place)
+	names := make(map[string]bool)
+	positions := make(map[point]string)
+	for _, p := range e.Places {
+		if _, found := places[p.ID]; found {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Duplicate place ID %d", p.ID)
+		}
+		if len(p.Name) == 0 || names[p.Name] {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Empty or duplicate place name %q", p.Name)
+		}
+		if other, found := positions[placePoint(p)]; found {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Places %s and %s are both at (%d, %d)", other, p.Name, p.X, p.Y)
+		}
+		if p.RegionID != 0 && !regions[p.RegionID] {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Place %s belongs to unknown region %d", p.Name, p.RegionID)
+		}
+		places[p.ID] = p
+		names[p.Name] = true
+		positions[placePoint(p)] = p.Name
+	}
+
+	// Segments are indexed with cells about as large as wormholes are long,
+	// so that each one is only checked against nearby ones.
+	var totalLength float64
+	for _, w := range e.Wormholes {
+		if src, dst := places[w.SourceID], places[w.DestinationID]; src != nil && dst != nil {
+			totalLength += dist(placePoint(src), placePoint(dst))
+		}
+	}
+	index := newGrid(totalLength / math.Max(1, float64(len(e.Wormholes))))
+	// seen holds the traversable directions of the wormholes checked so far;
+	// two one-way wormholes may link the same places in opposite directions.
+	seen := make(map[[2]int64]bool)
+	for _, w := range e.Wormholes {
+		src, srcFound := places[w.SourceID]
+		dst, dstFound := places[w.DestinationID]
+		if !srcFound || !dstFound {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Wormhole %d -> %d links unknown places", w.SourceID, w.DestinationID)
+		}
+		if src.ID == dst.ID {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Wormhole links place %s to itself", src.Name)
+		}
+		switch w.Kind {
+		case "", model.WormholeNormal, model.WormholeJump, model.WormholeOneWay:
+		default:
+			return mwkerr.New(mwkerr.InvalidUniverse, "Unknown kind %q of wormhole between %s and %s", w.Kind, src.Name, dst.Name)
+		}
+		if w.CostMultiplier < 0 {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Negative cost multiplier of wormhole between %s and %s", src.Name, dst.Name)
+		}
+		directions := [][2]int64{{src.ID, dst.ID}, {dst.ID, src.ID}}
+		if w.Kind == model.WormholeOneWay {
+			directions = directions[:1]
+		}
+		for _, d := range directions {
+			if seen[d] {
+				return mwkerr.New(mwkerr.InvalidUniverse, "Duplicate wormhole between %s and %s", src.Name, dst.Name)
+			}
+		}
+		for _, d := range directions {
+			seen[d] = true
+		}
+		if w.Kind == model.WormholeJump {
+			continue
+		}
+		s := newSegment(placePoint(src), placePoint(dst))
+		if index.intersect(s) {
+			return mwkerr.New(mwkerr.InvalidUniverse, "Wormhole between %s and %s crosses another one", src.Name, dst.Name)
+		}
+		index.addSegments(s)
+	}
+	return nil
+}
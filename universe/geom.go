@@ -11,6 +11,13 @@ func (p point) equal(b point) bool {
 	return p.x == b.x && p.y == b.y
 }
 
+func (p point) less(b point) bool {
+	if p.x != b.x {
+		return p.x < b.x
+	}
+	return p.y < b.y
+}
+
 func dist(p1, p2 point) float64 {
 	return math.Sqrt((p1.x-p2.x)*(p1.x-p2.x) + (p1.y-p2.y)*(p1.y-p2.y))
 }
@@ -44,6 +51,22 @@ type segment struct {
 	b point
 }
 
+// newSegment returns the segment between two points, with its ends in a
+// canonical order so that segments (a, b) and (b, a) are equal.
+func newSegment(a, b point) segment {
+	if b.less(a) {
+		a, b = b, a
+	}
+	return segment{a: a, b: b}
+}
+
+func (s segment) less(s2 segment) bool {
+	if !s.a.equal(s2.a) {
+		return s.a.less(s2.a)
+	}
+	return s.b.less(s2.b)
+}
+
 func (s segment) equal(s2 segment) bool {
 	return s.a.equal(s2.a) && s.b.equal(s2.b)
 }
@@ -0,0 +1,114 @@
+package universe
+
+import "math"
+
+type cellKey struct {
+	x int
+	y int
+}
+
+/*
+grid is a spatial index over square cells of the plane.
+
+Points are stored in the cell that contains them; segments are stored in every
+cell their bounding box overlaps. Looking for points near a point, or for
+segments that may cross a segment, only scans the few cells around it instead
+of every point or segment.
+*/
+type grid struct {
+	cellSize float64
+	points   map[cellKey][]point
+	segments map[cellKey][]segment
+}
+
+// newGrid creates an empty grid; cellSize should be close to the distances
+// that will be searched.
+func newGrid(cellSize float64) *grid {
+	if cellSize < 1 {
+		cellSize = 1
+	}
+	return &grid{
+		cellSize: cellSize,
+		points:   make(map[cellKey][]point),
+		segments: make(map[cellKey][]segment),
+	}
+}
+
+func (g *grid) key(p point) cellKey {
+	return cellKey{x: int(math.Floor(p.x / g.cellSize)), y: int(math.Floor(p.y / g.cellSize))}
+}
+
+// cells calls f for every cell overlapping the rectangle between a and b,
+// until f returns false.
+func (g *grid) cells(a, b point, f func(k cellKey) bool) {
+	ka, kb := g.key(point{x: math.Min(a.x, b.x), y: math.Min(a.y, b.y)}), g.key(point{x: math.Max(a.x, b.x), y: math.Max(a.y, b.y)})
+	for x := ka.x; x <= kb.x; x++ {
+		for y := ka.y; y <= kb.y; y++ {
+			if !f(cellKey{x: x, y: y}) {
+				return
+			}
+		}
+	}
+}
+
+func (g *grid) addPoints(points ...point) {
+	for _, p := range points {
+		k := g.key(p)
+		g.points[k] = append(g.points[k], p)
+	}
+}
+
+// aroundPoints calls f for every point of the grid that may be at most
+// distance d from p, until f returns false.
+func (g *grid) aroundPoints(p point, d float64, f func(p2 point) bool) {
+	g.cells(point{x: p.x - d, y: p.y - d}, point{x: p.x + d, y: p.y + d}, func(k cellKey) bool {
+		for _, p2 := range g.points[k] {
+			if !f(p2) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// farEnough is the same as point.farEnough, for all points of the grid.
+func (g *grid) farEnough(p point, minDist float64) bool {
+	ok := true
+	g.aroundPoints(p, minDist, func(p2 point) bool {
+		ok = p.farEnough(minDist, p2)
+		return ok
+	})
+	return ok
+}
+
+// neighbours returns the points of the grid at most maxDist from p, p
+// excluded.
+func (g *grid) neighbours(p point, maxDist float64) []point {
+	points := make([]point, 0)
+	g.aroundPoints(p, maxDist, func(p2 point) bool {
+		if !p.equal(p2) && dist(p, p2) <= maxDist {
+			points = append(points, p2)
+		}
+		return true
+	})
+	return points
+}
+
+func (g *grid) addSegments(segments ...segment) {
+	for _, s := range segments {
+		g.cells(s.a, s.b, func(k cellKey) bool {
+			g.segments[k] = append(g.segments[k], s)
+			return true
+		})
+	}
+}
+
+// intersect is the same as segment.intersect, for all segments of the grid.
+func (g *grid) intersect(s segment) bool {
+	found := false
+	g.cells(s.a, s.b, func(k cellKey) bool {
+		found = s.intersect(g.segments[k]...)
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,226 @@
+package universe
+
+import (
+	"github.com/morluque/moenawark/loglevel"
+	"github.com/morluque/moenawark/model"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const (
+	benchSide    = 100
+	benchSpacing = 20.0
+	benchMaxWay  = 40.0
+)
+
+// benchPoints returns 10k points on a jittered square lattice, like places
+// generated at a minimum distance from each other.
+func benchPoints() []point {
+	rnd := rand.New(rand.NewSource(1))
+	points := make([]point, 0, benchSide*benchSide)
+	for i := 0; i < benchSide; i++ {
+		for j := 0; j < benchSide; j++ {
+			points = append(points, point{
+				x: float64(i)*benchSpacing + rnd.Float64()*benchSpacing/4,
+				y: float64(j)*benchSpacing + rnd.Float64()*benchSpacing/4,
+			})
+		}
+	}
+	return points
+}
+
+// benchSegments links each point of benchPoints to its lattice neighbours on
+// the right and below.
+func benchSegments(points []point) []segment {
+	segments := make([]segment, 0, 2*len(points))
+	for i := 0; i < benchSide; i++ {
+		for j := 0; j < benchSide; j++ {
+			p := points[i*benchSide+j]
+			if i+1 < benchSide {
+				segments = append(segments, newSegment(p, points[(i+1)*benchSide+j]))
+			}
+			if j+1 < benchSide {
+				segments = append(segments, newSegment(p, points[i*benchSide+j+1]))
+			}
+		}
+	}
+	return segments
+}
+
+func benchQueries(n int) []point {
+	rnd := rand.New(rand.NewSource(2))
+	queries := make([]point, n)
+	for i := range queries {
+		queries[i] = point{x: rnd.Float64() * benchSide * benchSpacing, y: rnd.Float64() * benchSide * benchSpacing}
+	}
+	return queries
+}
+
+// TestGrid checks that the grid gives the same results as linear scans of
+// all points and segments.
+func TestGrid(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	randPoint := func() point {
+		return point{x: rnd.Float64()*600 - 100, y: rnd.Float64()*600 - 100}
+	}
+	points := make([]point, 400)
+	for i := range points {
+		points[i] = randPoint()
+	}
+	queries := append([]point{}, points[:50]...)
+	for i := 0; i < 200; i++ {
+		queries = append(queries, randPoint())
+	}
+	sortPoints := func(points []point) []point {
+		sort.Slice(points, func(i, j int) bool {
+			if points[i].x != points[j].x {
+				return points[i].x < points[j].x
+			}
+			return points[i].y < points[j].y
+		})
+		return points
+	}
+
+	tests := []struct {
+		name     string
+		cellSize float64
+		distance float64
+	}{
+		{"cells of the distance", 30, 30},
+		{"cells smaller than the distance", 7, 45},
+		{"cells larger than the distance", 200, 15},
+		{"cells below one", 0.5, 20},
+	}
+	for _, test := range tests {
+		index := newGrid(test.cellSize)
+		index.addPoints(points...)
+		segments := make([]segment, 0)
+		for _, a := range points {
+			for _, b := range index.neighbours(a, test.distance) {
+				if rnd.Intn(4) == 0 {
+					segments = append(segments, newSegment(a, b))
+				}
+			}
+		}
+		index.addSegments(segments...)
+
+		for _, q := range queries {
+			if got, want := index.farEnough(q, test.distance), q.farEnough(test.distance, points...); got != want {
+				t.Errorf("%s: farEnough(%v) = %v, want %v", test.name, q, got, want)
+			}
+			want := make([]point, 0)
+			for _, p := range points {
+				if !q.equal(p) && dist(q, p) <= test.distance {
+					want = append(want, p)
+				}
+			}
+			if got := sortPoints(index.neighbours(q, test.distance)); !reflect.DeepEqual(got, sortPoints(want)) {
+				t.Errorf("%s: neighbours(%v) = %v, want %v", test.name, q, got, want)
+			}
+			s := newSegment(q, point{x: q.x + test.distance*(rnd.Float64()-0.5), y: q.y + test.distance*(rnd.Float64()-0.5)})
+			if got, want := index.intersect(s), s.intersect(segments...); got != want {
+				t.Errorf("%s: intersect(%v) = %v, want %v", test.name, s, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkFarEnough(b *testing.B) {
+	points := benchPoints()
+	queries := benchQueries(1024)
+	b.Run("grid", func(b *testing.B) {
+		index := newGrid(benchSpacing)
+		index.addPoints(points...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			index.farEnough(queries[i%len(queries)], benchSpacing)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			queries[i%len(queries)].farEnough(benchSpacing, points...)
+		}
+	})
+}
+
+func BenchmarkNeighbors(b *testing.B) {
+	points := benchPoints()
+	b.Run("grid", func(b *testing.B) {
+		index := newGrid(benchMaxWay)
+		index.addPoints(points...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			index.neighbours(points[i%len(points)], benchMaxWay)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			a := points[i%len(points)]
+			neighbours := make([]point, 0)
+			for _, p := range points {
+				if !a.equal(p) && dist(a, p) <= benchMaxWay {
+					neighbours = append(neighbours, p)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	points := benchPoints()
+	segments := benchSegments(points)
+	queries := benchQueries(1024)
+	diagonals := make([]segment, len(queries))
+	for i, q := range queries {
+		diagonals[i] = newSegment(q, point{x: q.x + benchMaxWay/2, y: q.y + benchMaxWay/2})
+	}
+	b.Run("grid", func(b *testing.B) {
+		index := newGrid(benchMaxWay)
+		index.addSegments(segments...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			index.intersect(diagonals[i%len(diagonals)])
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			diagonals[i%len(diagonals)].intersect(segments...)
+		}
+	})
+}
+
+// BenchmarkGenerate generates a universe of about 10k places and its
+// wormholes, without saving them to database.
+func BenchmarkGenerate(b *testing.B) {
+	log.SetLevel(loglevel.Error)
+	defer log.SetLevel(loglevel.Debug)
+	cfg := Config{
+		Radius:       2700,
+		MinPlaceDist: 40,
+		MaxWayLength: 75,
+		RegionConfig: RegionConfig{Count: 5, Radius: 120, MinPlaceDist: 20, MaxWayLength: 40},
+		Seed:         1,
+	}
+	for i := 0; i < b.N; i++ {
+		u := newUniverse(cfg)
+		u.generateRegions()
+		u.generatePoints()
+		u.generateSegments()
+		if _, err := u.connect(); err != nil {
+			b.Fatal(err)
+		}
+		places := make([]*model.Place, 0)
+		for _, p := range u.allPoints() {
+			places = append(places, model.NewPlace("", int(p.x), int(p.y)))
+		}
+		index := placesByPoint(places)
+		for _, s := range u.allSegments() {
+			wormholeFromSegment(s, index)
+		}
+		if i == 0 {
+			b.Logf("%d places", len(places))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package universe
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/morluque/moenawark/model"
+	"math/rand"
+)
+
+/*
+Load rebuilds a universe that was previously generated and saved to the
+database.
+
+Regions, places and wormholes are loaded from their tables; configuration and
+seed are taken from the first universe generation record, if any.
+*/
+func Load(tx *sql.Tx) (*Universe, error) {
+	generations, err := model.LoadUniverseGenerations(tx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{}
+	if len(generations) > 0 {
+		if err := json.Unmarshal([]byte(generations[0].ConfigJSON), &cfg); err != nil {
+			return nil, err
+		}
+		cfg.Seed = generations[0].Seed
+	}
+
+	u := &Universe{Config: cfg}
+	u.rnd = rand.New(rand.NewSource(u.Seed))
+	u.Region = newRegion(point{x: u.Radius, y: u.Radius}, u.Radius)
+	u.Regions = make([]*Region, 0)
+	byID := make(map[int64]*Region)
+	regions, err := model.LoadAllRegions(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range regions {
+		r := newRegion(point{x: float64(m.X), y: float64(m.Y)}, float64(m.Radius))
+		r.ID, r.Name = m.ID, m.Name
+		u.Regions = append(u.Regions, r)
+		byID[r.ID] = r
+	}
+
+	if u.Places, err = model.LoadAllPlaces(tx); err != nil {
+		return nil, err
+	}
+	u.names = make(map[string]bool)
+	for _, r := range u.Regions {
+		u.names[r.Name] = true
+	}
+	for _, p := range u.Places {
+		u.names[p.Name] = true
+		pt := placePoint(p)
+		if r, ok := byID[p.RegionID]; ok {
+			r.points = append(r.points, pt)
+		} else {
+			u.Region.points = append(u.Region.points, pt)
+		}
+	}
+	if u.Wormholes, err = model.LoadAllWormholes(tx); err != nil {
+		return nil, err
+	}
+	for _, w := range u.Wormholes {
+		if w.Kind == model.WormholeJump {
+			// Jumps may cross other wormholes, they are not ways.
+			continue
+		}
+		u.Region.segments = append(u.Region.segments, newSegment(placePoint(&w.Source), placePoint(&w.Destination)))
+	}
+	log.Infof("Loaded universe of %d regions, %d places and %d wormholes", len(u.Regions), len(u.Places), len(u.Wormholes))
+
+	return u, nil
+}
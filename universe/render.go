@@ -0,0 +1,307 @@
+package universe
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// RenderOptions tells how to draw a map of the universe.
+type RenderOptions struct {
+	// Scale is the number of pixels per universe unit; it defaults to 1.
+	Scale float64
+	// Highlight, if not nil, is what a character knows of the universe:
+	// places it visited or senses, and wormholes between them, are
+	// highlighted while the rest is dimmed.
+	Highlight model.Visibility
+}
+
+// mapMargin is the space left around places and regions, in universe units.
+const mapMargin = 20
+
+// Limits of map sizes, so that drawing a map can't exhaust memory.
+const (
+	// MaxMapScale is the largest number of pixels per universe unit.
+	MaxMapScale = 10
+	// MaxMapSide is the largest width or height of a map, in pixels.
+	MaxMapSide = 8192
+)
+
+type mapStyle int
+
+const (
+	styleNormal mapStyle = iota
+	styleVisited
+	styleSensed
+	styleUnknown
+)
+
+var mapColors = map[mapStyle]color.RGBA{
+	styleNormal:  {0xe0, 0xe0, 0xe0, 0xff},
+	styleVisited: {0xff, 0xd0, 0x40, 0xff},
+	styleSensed:  {0x70, 0xc0, 0xff, 0xff},
+	styleUnknown: {0x50, 0x58, 0x68, 0xff},
+}
+
+var (
+	mapBackground   = color.RGBA{0x10, 0x12, 0x1a, 0xff}
+	mapRegionColor  = color.RGBA{0x4a, 0x5a, 0x80, 0xff}
+	mapWormholeLink = color.RGBA{0x70, 0x80, 0xa0, 0xff}
+)
+
+// svgDash returns the SVG attribute drawing special wormholes with dashes:
+// long ones for jumps, short ones for one-way wormholes.
+func svgDash(w *model.Wormhole) string {
+	switch w.Kind {
+	case model.WormholeJump:
+		return " stroke-dasharray=\"8,4\""
+	case model.WormholeOneWay:
+		return " stroke-dasharray=\"2,2\""
+	}
+	return ""
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (o RenderOptions) scale() float64 {
+	if o.Scale <= 0 {
+		return 1
+	}
+	return o.Scale
+}
+
+func (o RenderOptions) placeStyle(p *model.Place) mapStyle {
+	if o.Highlight == nil {
+		return styleNormal
+	}
+	visited, ok := o.Highlight[p.ID]
+	if !ok {
+		return styleUnknown
+	}
+	if visited {
+		return styleVisited
+	}
+	return styleSensed
+}
+
+func (o RenderOptions) wormholeColor(w *model.Wormhole) color.RGBA {
+	if o.Highlight != nil && !o.Highlight.CanSeeWormhole(w) {
+		return mapColors[styleUnknown]
+	}
+	return mapWormholeLink
+}
+
+// mapFrame converts universe coordinates to image coordinates.
+type mapFrame struct {
+	xMin, yMin    float64
+	scale         float64
+	width, height int
+}
+
+func (u *Universe) mapFrame(opts RenderOptions) mapFrame {
+	xMin, yMin := math.Inf(1), math.Inf(1)
+	xMax, yMax := math.Inf(-1), math.Inf(-1)
+	extend := func(x, y, r float64) {
+		xMin, yMin = math.Min(xMin, x-r), math.Min(yMin, y-r)
+		xMax, yMax = math.Max(xMax, x+r), math.Max(yMax, y+r)
+	}
+	for _, p := range u.Places {
+		extend(float64(p.X), float64(p.Y), 0)
+	}
+	for _, r := range u.Regions {
+		extend(r.Center.x, r.Center.y, r.Radius)
+	}
+	if math.IsInf(xMin, 1) {
+		xMin, yMin, xMax, yMax = 0, 0, 0, 0
+	}
+	f := mapFrame{xMin: xMin - mapMargin, yMin: yMin - mapMargin, scale: opts.scale()}
+	f.width = int(math.Ceil((xMax - xMin + 2*mapMargin) * f.scale))
+	f.height = int(math.Ceil((yMax - yMin + 2*mapMargin) * f.scale))
+	return f
+}
+
+// CheckMapSize returns an error if a map drawn with these options would be
+// larger than MaxMapScale or MaxMapSide allow.
+func (u *Universe) CheckMapSize(opts RenderOptions) error {
+	if opts.Scale > MaxMapScale {
+		return fmt.Errorf("map scale %g is above the maximum of %d", opts.Scale, MaxMapScale)
+	}
+	f := u.mapFrame(opts)
+	if f.width > MaxMapSide || f.height > MaxMapSide {
+		return fmt.Errorf("map of %dx%d pixels is larger than the maximum of %dx%d, lower the scale",
+			f.width, f.height, MaxMapSide, MaxMapSide)
+	}
+	return nil
+}
+
+func (f mapFrame) project(x, y float64) (float64, float64) {
+	return (x - f.xMin) * f.scale, (y - f.yMin) * f.scale
+}
+
+func (f mapFrame) projectPlace(p *model.Place) (float64, float64) {
+	return f.project(float64(p.X), float64(p.Y))
+}
+
+/*
+WriteSVG draws a map of the universe in SVG format.
+
+Regions are drawn as circles, wormholes as lines labelled with their
+distance, and places as dots labelled with their name.
+*/
+func (u *Universe) WriteSVG(w io.Writer, opts RenderOptions) error {
+	f := u.mapFrame(opts)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		f.width, f.height, f.width, f.height)
+	fmt.Fprintf(bw, "<style>text { font-family: sans-serif; font-size: %.1fpx; }</style>\n", 10*math.Sqrt(f.scale))
+	fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"%s\"/>\n", hexColor(mapBackground))
+
+	fmt.Fprintf(bw, "<g id=\"regions\" stroke=\"%s\" fill=\"%s\" fill-opacity=\"0.25\">\n",
+		hexColor(mapRegionColor), hexColor(mapRegionColor))
+	for _, r := range u.Regions {
+		x, y := f.project(r.Center.x, r.Center.y)
+		fmt.Fprintf(bw, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"%.1f\"/>\n", x, y, r.Radius*f.scale)
+		if len(r.Name) > 0 {
+			fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\" stroke=\"none\">%s</text>\n",
+				x, y-r.Radius*f.scale-4, html.EscapeString(r.Name))
+		}
+	}
+	fmt.Fprint(bw, "</g>\n")
+
+	fmt.Fprint(bw, "<g id=\"wormholes\">\n")
+	for _, wh := range u.Wormholes {
+		x1, y1 := f.projectPlace(&wh.Source)
+		x2, y2 := f.projectPlace(&wh.Destination)
+		c := hexColor(opts.wormholeColor(wh))
+		fmt.Fprintf(bw, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"%s\"%s/>\n", x1, y1, x2, y2, c, svgDash(wh))
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\" fill=\"%s\" font-size=\"75%%\">%d</text>\n",
+			(x1+x2)/2, (y1+y2)/2, c, wh.Distance)
+	}
+	fmt.Fprint(bw, "</g>\n")
+
+	fmt.Fprint(bw, "<g id=\"places\">\n")
+	for _, p := range u.Places {
+		x, y := f.projectPlace(p)
+		c := hexColor(mapColors[opts.placeStyle(p)])
+		fmt.Fprintf(bw, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"3\" fill=\"%s\"><title>%s</title></circle>\n",
+			x, y, c, html.EscapeString(p.Name))
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" fill=\"%s\">%s</text>\n", x+5, y-5, c, html.EscapeString(p.Name))
+	}
+	fmt.Fprint(bw, "</g>\n")
+	fmt.Fprint(bw, "</svg>\n")
+
+	return bw.Flush()
+}
+
+/*
+WritePNG draws a map of the universe in PNG format.
+
+The map is the same as the SVG one; names and distances are drawn with a small
+bitmap font whose size doesn't follow the scale. Maps larger than
+CheckMapSize allows are refused.
+*/
+func (u *Universe) WritePNG(w io.Writer, opts RenderOptions) error {
+	if err := u.CheckMapSize(opts); err != nil {
+		return err
+	}
+	f := u.mapFrame(opts)
+	img := image.NewRGBA(image.Rect(0, 0, f.width, f.height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = mapBackground.R, mapBackground.G, mapBackground.B, mapBackground.A
+	}
+
+	for _, r := range u.Regions {
+		x, y := f.project(r.Center.x, r.Center.y)
+		radius := int(r.Radius * f.scale)
+		drawCircle(img, int(x), int(y), radius, mapRegionColor)
+		drawText(img, int(x)-textWidth(r.Name)/2, int(y)-radius-4-glyphHeight, r.Name, mapRegionColor)
+	}
+	for _, wh := range u.Wormholes {
+		x1, y1 := f.projectPlace(&wh.Source)
+		x2, y2 := f.projectPlace(&wh.Destination)
+		c := opts.wormholeColor(wh)
+		drawLine(img, int(x1), int(y1), int(x2), int(y2), c)
+		distance := strconv.Itoa(wh.Distance)
+		drawText(img, int((x1+x2)/2)-textWidth(distance)/2, int((y1+y2)/2)-glyphHeight/2, distance, c)
+	}
+	for _, p := range u.Places {
+		x, y := f.projectPlace(p)
+		c := mapColors[opts.placeStyle(p)]
+		drawDisc(img, int(x), int(y), 2, c)
+		drawText(img, int(x)+5, int(y)-5-glyphHeight, p.Name, c)
+	}
+
+	return png.Encode(w, img)
+}
+
+// drawLine draws a segment with Bresenham's algorithm.
+func drawLine(img *image.RGBA, x1, y1, x2, y2 int, c color.RGBA) {
+	dx, dy := abs(x2-x1), -abs(y2-y1)
+	sx, sy := 1, 1
+	if x1 > x2 {
+		sx = -1
+	}
+	if y1 > y2 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x1, y1, c)
+		if x1 == x2 && y1 == y2 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x1 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y1 += sy
+		}
+	}
+}
+
+// drawCircle draws the outline of a circle with the midpoint algorithm.
+func drawCircle(img *image.RGBA, cx, cy, r int, c color.RGBA) {
+	x, y, e := r, 0, 1-r
+	for x >= y {
+		for _, d := range [][2]int{{x, y}, {y, x}, {-y, x}, {-x, y}, {-x, -y}, {-y, -x}, {y, -x}, {x, -y}} {
+			img.SetRGBA(cx+d[0], cy+d[1], c)
+		}
+		y++
+		if e < 0 {
+			e += 2*y + 1
+		} else {
+			x--
+			e += 2*(y-x) + 1
+		}
+	}
+}
+
+func drawDisc(img *image.RGBA, cx, cy, r int, c color.RGBA) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(cx+dx, cy+dy, c)
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
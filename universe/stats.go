@@ -0,0 +1,203 @@
+package universe
+
+import (
+	"fmt"
+	"github.com/morluque/moenawark/model"
+	"io"
+	"sort"
+)
+
+// RegionStats counts the places of a region.
+type RegionStats struct {
+	Name   string `json:"name"`
+	Places int    `json:"places"`
+}
+
+// NameStats describes the names of places and regions.
+type NameStats struct {
+	Count         int     `json:"count"`
+	MinLength     int     `json:"min_length"`
+	MaxLength     int     `json:"max_length"`
+	AverageLength float64 `json:"average_length"`
+}
+
+/*
+Stats is a report on the structure of a universe.
+
+Path lengths and the diameter are counted in wormholes to traverse.
+*/
+type Stats struct {
+	Places             int             `json:"places"`
+	Wormholes          int             `json:"wormholes"`
+	Regions            []RegionStats   `json:"regions"`
+	OutsideRegions     int             `json:"outside_regions"`
+	Components         int             `json:"components"`
+	DegreeDistribution map[int]int     `json:"degree_distribution"`
+	MinDegree          int             `json:"min_degree"`
+	MaxDegree          int             `json:"max_degree"`
+	AverageDegree      float64         `json:"average_degree"`
+	Diameter           int             `json:"diameter"`
+	AveragePathLength  float64         `json:"average_path_length"`
+	DeadEnds           []string        `json:"dead_ends"`
+	ArticulationPoints []string        `json:"articulation_points"`
+	LongestWormhole    *model.Wormhole `json:"longest_wormhole"`
+	Names              NameStats       `json:"names"`
+}
+
+// Stats computes a report on the structure of the universe.
+func (u *Universe) Stats() *Stats {
+	s := &Stats{
+		Places:             len(u.Places),
+		Wormholes:          len(u.Wormholes),
+		Regions:            make([]RegionStats, 0, len(u.Regions)),
+		DegreeDistribution: make(map[int]int),
+		DeadEnds:           make([]string, 0),
+		ArticulationPoints: make([]string, 0),
+	}
+
+	regionPlaces := make(map[int64]int)
+	for _, p := range u.Places {
+		if p.RegionID > 0 {
+			regionPlaces[p.RegionID]++
+		} else {
+			s.OutsideRegions++
+		}
+	}
+	for _, r := range u.Regions {
+		s.Regions = append(s.Regions, RegionStats{Name: r.Name, Places: regionPlaces[r.ID]})
+	}
+
+	g := model.NewWormholeGraph(u.Places, u.Wormholes)
+	s.Components = len(g.Components())
+	total := 0
+	for i, id := range g.PlaceIDs() {
+		d := g.Degree(id)
+		s.DegreeDistribution[d]++
+		total += d
+		if i == 0 || d < s.MinDegree {
+			s.MinDegree = d
+		}
+		if d > s.MaxDegree {
+			s.MaxDegree = d
+		}
+		if d == 1 {
+			s.DeadEnds = append(s.DeadEnds, g.Place(id).Name)
+		}
+	}
+	if s.Places > 0 {
+		s.AverageDegree = float64(total) / float64(s.Places)
+	}
+	s.Diameter, s.AveragePathLength = g.PathStats()
+	for _, id := range g.ArticulationPoints() {
+		s.ArticulationPoints = append(s.ArticulationPoints, g.Place(id).Name)
+	}
+	for _, w := range u.Wormholes {
+		if s.LongestWormhole == nil || w.Distance > s.LongestWormhole.Distance {
+			s.LongestWormhole = w
+		}
+	}
+
+	names := make([]string, 0, len(u.Places)+len(u.Regions))
+	for _, r := range u.Regions {
+		names = append(names, r.Name)
+	}
+	for _, p := range u.Places {
+		names = append(names, p.Name)
+	}
+	s.Names = nameStats(names)
+
+	return s
+}
+
+func nameStats(names []string) NameStats {
+	ns := NameStats{Count: len(names)}
+	total := 0
+	for i, name := range names {
+		l := len([]rune(name))
+		total += l
+		if i == 0 || l < ns.MinLength {
+			ns.MinLength = l
+		}
+		if l > ns.MaxLength {
+			ns.MaxLength = l
+		}
+	}
+	if ns.Count > 0 {
+		ns.AverageLength = float64(total) / float64(ns.Count)
+	}
+	return ns
+}
+
+// WriteText writes the report in a human readable form.
+func (s *Stats) WriteText(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("Places:               %d (%d outside regions)", s.Places, s.OutsideRegions),
+		fmt.Sprintf("Wormholes:            %d", s.Wormholes),
+		fmt.Sprintf("Connected components: %d", s.Components),
+	}
+	for _, r := range s.Regions {
+		lines = append(lines, fmt.Sprintf("Region %-13s %d places", r.Name+":", r.Places))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Degree:               min %d, max %d, average %.2f", s.MinDegree, s.MaxDegree, s.AverageDegree))
+	degrees := make([]int, 0, len(s.DegreeDistribution))
+	for d := range s.DegreeDistribution {
+		degrees = append(degrees, d)
+	}
+	sort.Ints(degrees)
+	for _, d := range degrees {
+		lines = append(lines, fmt.Sprintf("  %3d wormholes:      %d places", d, s.DegreeDistribution[d]))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Diameter:             %d hops", s.Diameter),
+		fmt.Sprintf("Average path length:  %.2f hops", s.AveragePathLength),
+		fmt.Sprintf("Dead-ends:            %d", len(s.DeadEnds)),
+		fmt.Sprintf("Articulation points:  %d", len(s.ArticulationPoints)))
+	if s.LongestWormhole != nil {
+		lines = append(lines, fmt.Sprintf("Longest wormhole:     %s - %s, %d",
+			s.LongestWormhole.Source.Name, s.LongestWormhole.Destination.Name, s.LongestWormhole.Distance))
+	}
+	lines = append(lines, fmt.Sprintf("Names:                %d, length min %d, max %d, average %.2f",
+		s.Names.Count, s.Names.MinLength, s.Names.MaxLength, s.Names.AverageLength))
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ValidationConfig holds thresholds a universe must respect; zero values are
+not checked.
+*/
+type ValidationConfig struct {
+	MinPlaces             int `json:"min_places"`
+	MinDegree             int `json:"min_degree"`
+	MaxDiameter           int `json:"max_diameter"`
+	MaxDeadEnds           int `json:"max_dead_ends"`
+	MaxArticulationPoints int `json:"max_articulation_points"`
+}
+
+// Validate returns a description of every threshold the universe violates.
+func (s *Stats) Validate(v ValidationConfig) []string {
+	violations := make([]string, 0)
+	if v.MinPlaces > 0 && s.Places < v.MinPlaces {
+		violations = append(violations, fmt.Sprintf("%d places, less than %d", s.Places, v.MinPlaces))
+	}
+	if v.MinDegree > 0 && s.MinDegree < v.MinDegree {
+		violations = append(violations, fmt.Sprintf("minimum degree %d, less than %d", s.MinDegree, v.MinDegree))
+	}
+	if v.MaxDiameter > 0 && s.Diameter > v.MaxDiameter {
+		violations = append(violations, fmt.Sprintf("diameter %d, more than %d", s.Diameter, v.MaxDiameter))
+	}
+	if v.MaxDeadEnds > 0 && len(s.DeadEnds) > v.MaxDeadEnds {
+		violations = append(violations, fmt.Sprintf("%d dead-ends, more than %d", len(s.DeadEnds), v.MaxDeadEnds))
+	}
+	if v.MaxArticulationPoints > 0 && len(s.ArticulationPoints) > v.MaxArticulationPoints {
+		violations = append(violations, fmt.Sprintf("%d articulation points, more than %d",
+			len(s.ArticulationPoints), v.MaxArticulationPoints))
+	}
+	return violations
+}
@@ -0,0 +1,248 @@
+package universe
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Link strategies tell how places are linked by wormholes.
+const (
+	// LinkRandom keeps non-crossing ways in random order; it gives uneven
+	// graphs.
+	LinkRandom = "random"
+	// LinkDelaunay keeps the ways of the Delaunay triangulation of places.
+	LinkDelaunay = "delaunay"
+	// LinkGabriel keeps the ways of the Gabriel graph: there is no other
+	// place in the circle whose diameter is the way.
+	LinkGabriel = "gabriel"
+	// LinkRNG keeps the ways of the relative neighborhood graph: there is no
+	// other place closer to both ends of the way than they are to each
+	// other.
+	LinkRNG = "rng"
+	// LinkMST keeps the ways of the minimum spanning tree, plus a fraction
+	// of other Delaunay ways picked at random.
+	LinkMST = "mst"
+)
+
+func checkLinkStrategy(strategy string) error {
+	switch strategy {
+	case "", LinkRandom, LinkDelaunay, LinkGabriel, LinkRNG, LinkMST:
+		return nil
+	}
+	return fmt.Errorf("unknown link strategy %q", strategy)
+}
+
+/*
+linkSegments generates ways from source points to other source points or to
+destination points, at most maxWayLength long and not crossing existing
+segments, following the link strategy of the universe.
+
+All strategies but the random one pick ways among the edges of the Delaunay
+triangulation of all points, which never cross each other.
+*/
+func (u *Universe) linkSegments(srcs, dsts []point, maxWayLength float64, existingSegments []segment) []segment {
+	dists := computeDists(srcs, dsts, maxWayLength)
+	if u.LinkStrategy == "" || u.LinkStrategy == LinkRandom {
+		return generateSegments(dists, existingSegments, u.rnd)
+	}
+
+	points := append(append([]point{}, srcs...), dsts...)
+	candidates := make([]segment, 0)
+	for _, s := range delaunayEdges(points) {
+		if _, ok := dists[s]; ok {
+			candidates = append(candidates, s)
+		}
+	}
+	index := newGrid(maxWayLength)
+	index.addPoints(points...)
+	var selected []segment
+	switch u.LinkStrategy {
+	case LinkDelaunay:
+		selected = candidates
+	case LinkGabriel:
+		selected = filterSegments(candidates, func(s segment) bool { return isGabrielEdge(s, index) })
+	case LinkRNG:
+		selected = filterSegments(candidates, func(s segment) bool { return isRelativeNeighbor(s, index) })
+	case LinkMST:
+		selected = spanningTreePlusExtras(candidates, u.ExtraEdgeFraction, u.rnd)
+	}
+
+	existing := newGrid(maxWayLength)
+	existing.addSegments(existingSegments...)
+	segments := filterSegments(selected, func(s segment) bool { return !existing.intersect(s) })
+	log.Infof("generated %d segments with %s strategy\n", len(segments), u.LinkStrategy)
+
+	return segments
+}
+
+func filterSegments(segments []segment, keep func(s segment) bool) []segment {
+	kept := make([]segment, 0, len(segments))
+	for _, s := range segments {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+func isGabrielEdge(s segment, index *grid) bool {
+	center := point{x: (s.a.x + s.b.x) / 2, y: (s.a.y + s.b.y) / 2}
+	radius := dist(s.a, s.b) / 2
+	for _, p := range index.neighbours(center, radius) {
+		if !p.equal(s.a) && !p.equal(s.b) && dist(center, p) < radius-EPSILON {
+			return false
+		}
+	}
+	return true
+}
+
+func isRelativeNeighbor(s segment, index *grid) bool {
+	length := dist(s.a, s.b)
+	for _, p := range index.neighbours(s.a, length) {
+		if !p.equal(s.b) && dist(s.a, p) < length-EPSILON && dist(s.b, p) < length-EPSILON {
+			return false
+		}
+	}
+	return true
+}
+
+// spanningTreePlusExtras returns the minimum spanning forest of the
+// segments, followed by the given fraction of the other segments picked at
+// random.
+func spanningTreePlusExtras(segments []segment, extraFraction float64, rnd *rand.Rand) []segment {
+	sorted := append([]segment{}, segments...)
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := dist(sorted[i].a, sorted[i].b), dist(sorted[j].a, sorted[j].b)
+		if di != dj {
+			return di < dj
+		}
+		return sorted[i].less(sorted[j])
+	})
+	points := make([]point, 0, 2*len(sorted))
+	for _, s := range sorted {
+		points = append(points, s.a, s.b)
+	}
+	ps := newPointSet(points)
+	tree := make([]segment, 0)
+	others := make([]segment, 0)
+	for _, s := range sorted {
+		if ps.union(s.a, s.b) {
+			tree = append(tree, s)
+		} else {
+			others = append(others, s)
+		}
+	}
+	rnd.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	extras := int(math.Round(math.Max(0, math.Min(1, extraFraction)) * float64(len(others))))
+	log.Infof("spanning tree of %d segments, plus %d extra segments\n", len(tree), extras)
+	return append(tree, others[:extras]...)
+}
+
+type triangle struct {
+	a, b, c point
+	// center and squared radius of the circumcircle
+	center point
+	r2     float64
+}
+
+func newTriangle(a, b, c point) triangle {
+	t := triangle{a: a, b: b, c: c}
+	d := 2 * (a.x*(b.y-c.y) + b.x*(c.y-a.y) + c.x*(a.y-b.y))
+	if d == 0 {
+		// Flat triangle: its circumcircle contains everything, so that
+		// it is removed as soon as possible.
+		t.r2 = math.Inf(1)
+		return t
+	}
+	a2, b2, c2 := a.x*a.x+a.y*a.y, b.x*b.x+b.y*b.y, c.x*c.x+c.y*c.y
+	t.center = point{
+		x: (a2*(b.y-c.y) + b2*(c.y-a.y) + c2*(a.y-b.y)) / d,
+		y: (a2*(c.x-b.x) + b2*(a.x-c.x) + c2*(b.x-a.x)) / d,
+	}
+	t.r2 = (a.x-t.center.x)*(a.x-t.center.x) + (a.y-t.center.y)*(a.y-t.center.y)
+	return t
+}
+
+func (t triangle) edges() []segment {
+	return []segment{newSegment(t.a, t.b), newSegment(t.b, t.c), newSegment(t.c, t.a)}
+}
+
+func (t triangle) inCircumcircle(p point) bool {
+	dx, dy := p.x-t.center.x, p.y-t.center.y
+	return dx*dx+dy*dy < t.r2
+}
+
+// leftOf returns true if the circumcircle of the triangle is entirely left
+// of x.
+func (t triangle) leftOf(x float64) bool {
+	dx := x - t.center.x
+	return dx > 0 && dx*dx > t.r2
+}
+
+/*
+delaunayEdges returns the edges of the Delaunay triangulation of points,
+sorted, computed with the Bowyer-Watson algorithm.
+
+Points are inserted from left to right, so that triangles whose circumcircle
+is left of the last inserted point are final and needn't be checked anymore.
+*/
+func delaunayEdges(points []point) []segment {
+	if len(points) < 2 {
+		return []segment{}
+	}
+	sorted := append([]point{}, points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+
+	min, max := sorted[0], sorted[0]
+	for _, p := range sorted {
+		min = point{x: math.Min(min.x, p.x), y: math.Min(min.y, p.y)}
+		max = point{x: math.Max(max.x, p.x), y: math.Max(max.y, p.y)}
+	}
+	size := math.Max(max.x-min.x, max.y-min.y) + 1
+	mid := point{x: (min.x + max.x) / 2, y: (min.y + max.y) / 2}
+	super := newTriangle(
+		point{x: mid.x - 1000*size, y: mid.y - 1000*size},
+		point{x: mid.x, y: mid.y + 1000*size},
+		point{x: mid.x + 1000*size, y: mid.y - 1000*size})
+
+	active := []triangle{super}
+	final := make([]triangle, 0)
+	for _, p := range sorted {
+		boundary := make(map[segment]int)
+		kept := make([]triangle, 0, len(active)+2)
+		for _, t := range active {
+			if t.leftOf(p.x) {
+				final = append(final, t)
+			} else if t.inCircumcircle(p) {
+				for _, e := range t.edges() {
+					boundary[e]++
+				}
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		for e, n := range boundary {
+			if n == 1 {
+				kept = append(kept, newTriangle(e.a, e.b, p))
+			}
+		}
+		active = kept
+	}
+
+	isSuper := func(p point) bool { return p.equal(super.a) || p.equal(super.b) || p.equal(super.c) }
+	seen := make(map[segment]bool)
+	edges := make([]segment, 0)
+	for _, t := range append(final, active...) {
+		for _, e := range t.edges() {
+			if seen[e] || isSuper(e.a) || isSuper(e.b) {
+				continue
+			}
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].less(edges[j]) })
+	return edges
+}
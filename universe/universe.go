@@ -4,31 +4,53 @@ Package universe implements random universe generation for Moenawark.
 package universe
 
 import (
+	"bufio"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/morluque/moenawark/config"
 	"github.com/morluque/moenawark/loglevel"
 	"github.com/morluque/moenawark/markov"
 	"github.com/morluque/moenawark/model"
+	"github.com/morluque/moenawark/mwkerr"
+	"io"
+	"math"
 	"math/rand"
-	"os"
+	"sort"
+	"time"
 )
 
 // RegionConfig holds configuration for a universe region
 type RegionConfig struct {
-	Count        int
-	Radius       float64
-	MinPlaceDist float64
-	MaxWayLength float64
+	Count        int     `json:"count"`
+	Radius       float64 `json:"radius"`
+	MinPlaceDist float64 `json:"min_place_dist"`
+	MaxWayLength float64 `json:"max_way_length"`
+	// Corpora are names of Markov corpora, see markov.Register; each
+	// region picks one at random to name itself and its places. Regions use
+	// the default name generator if there is none.
+	Corpora []string `json:"corpora"`
 }
 
 // Config holds configuration for a random universe
 type Config struct {
-	Radius       float64
-	MinPlaceDist float64
-	MaxWayLength float64
-	RegionConfig RegionConfig
-	MarkovGen    *markov.Chains
+	Radius       float64               `json:"radius"`
+	MinPlaceDist float64               `json:"min_place_dist"`
+	MaxWayLength float64               `json:"max_way_length"`
+	RegionConfig RegionConfig          `json:"region"`
+	Energy       EnergyConfig          `json:"energy"`
+	Anomalies    AnomalyConfig         `json:"anomalies"`
+	MarkovGen    *markov.NameGenerator `json:"-"`
+	// LinkStrategy tells how places are linked, see the Link* constants;
+	// it defaults to LinkRandom.
+	LinkStrategy string `json:"link_strategy"`
+	// ExtraEdgeFraction is the fraction of ways not in the minimum
+	// spanning tree that are kept by the LinkMST strategy.
+	ExtraEdgeFraction float64 `json:"extra_edge_fraction"`
+	// Seed of the random generator; the same configuration, word list and
+	// seed always yield the same universe. Zero means a seed is picked from
+	// the current time.
+	Seed int64 `json:"seed"`
 }
 
 // Universe stores places and ways between them
@@ -38,7 +60,11 @@ type Universe struct {
 	Regions   []*Region
 	Places    []*model.Place
 	Wormholes []*model.Wormhole
-	names     map[string]bool
+	// Connectivity tells how the generated ways had to be completed so
+	// that every place can be reached.
+	Connectivity *ConnectivityReport
+	names        map[string]bool
+	rnd          *rand.Rand
 }
 
 var log *loglevel.Logger
@@ -56,6 +82,13 @@ func newUniverse(cfg Config) *Universe {
 	u := Universe{
 		Config: cfg,
 	}
+	if u.Seed == 0 {
+		u.Seed = time.Now().UnixNano()
+	}
+	u.rnd = rand.New(rand.NewSource(u.Seed))
+	if u.MarkovGen != nil {
+		u.MarkovGen.Seed(u.Seed)
+	}
 	u.Region = newRegion(point{x: u.Radius, y: u.Radius}, u.Radius)
 
 	return &u
@@ -63,8 +96,15 @@ func newUniverse(cfg Config) *Universe {
 
 // Region represent a circular region of universe with more places density
 type Region struct {
-	Center   point
-	Radius   float64
+	// ID and Name are set once the region is saved to the database.
+	ID     int64
+	Name   string
+	Center point
+	Radius float64
+	// Corpus is the name of the Markov corpus of the region's names, if
+	// it is not the default one.
+	Corpus   string
+	nameGen  *markov.NameGenerator
 	points   []point
 	segments []segment
 }
@@ -81,43 +121,30 @@ func newRegion(center point, radius float64) *Region {
 	return &r
 }
 
-// WriteDotFile exports a universe to Graphviz "dot" format
-func (u *Universe) WriteDotFile(path string) error {
-	scale := 20
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// WriteDot exports a universe to Graphviz "dot" format
+func (u *Universe) WriteDot(w io.Writer, opts RenderOptions) error {
+	scale := opts.scale()
+	bw := bufio.NewWriter(w)
 
-	_, err = f.WriteString("digraph G {\n")
-	if err != nil {
-		return err
-	}
+	fmt.Fprint(bw, "digraph G {\n")
 	for _, p := range u.Places {
-		_, err = f.WriteString(fmt.Sprintf("    p%d [pos=\"%d,%d!\"];\n", p.ID, p.X*scale, p.Y*scale))
-		if err != nil {
-			return err
-		}
+		fmt.Fprintf(bw, "    p%d [label=%q, pos=\"%d,%d!\"];\n", p.ID, p.Name, int(float64(p.X)*scale), int(float64(p.Y)*scale))
 	}
 	for _, w := range u.Wormholes {
-		_, err = f.WriteString(fmt.Sprintf("    p%d -> p%d;\n", w.Source.ID, w.Destination.ID))
-		if err != nil {
-			return err
-		}
+		fmt.Fprintf(bw, "    p%d -> p%d [label=\"%d\"];\n", w.Source.ID, w.Destination.ID, w.Distance)
 	}
-	_, err = f.WriteString("}\n")
-	return err
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
 }
 
 func (r *Region) containsPoint(p point) bool {
 	return dist(r.Center, p) <= r.Radius
 }
 
-func randPointInRegion(r *Region) point {
+func randPointInRegion(r *Region, rnd *rand.Rand) point {
 	for i := 0; i < 1000; i++ {
-		x := r.Center.x - r.Radius + rand.Float64()*r.Radius*2
-		y := r.Center.y - r.Radius + rand.Float64()*r.Radius*2
+		x := r.Center.x - r.Radius + rnd.Float64()*r.Radius*2
+		y := r.Center.y - r.Radius + rnd.Float64()*r.Radius*2
 		p := point{x: x, y: y}
 		if r.containsPoint(p) {
 			return p
@@ -126,17 +153,21 @@ func randPointInRegion(r *Region) point {
 	return point{}
 }
 
-func (r *Region) generatePoints(minPlaceDist float64, otherPoints []point) {
+func (r *Region) generatePoints(minPlaceDist float64, otherPoints []point, rnd *rand.Rand) {
+	index := newGrid(minPlaceDist)
+	index.addPoints(otherPoints...)
+	index.addPoints(r.points...)
 	fail := 0
 	for {
 		fail++
 		if fail > int(r.Radius)*100 {
 			break
 		}
-		newp := randPointInRegion(r)
-		if newp.farEnough(minPlaceDist, otherPoints...) && newp.farEnough(minPlaceDist, r.points...) {
+		newp := randPointInRegion(r, rnd)
+		if index.farEnough(newp, minPlaceDist) {
 			fail = 0
 			r.points = append(r.points, newp)
+			index.addPoints(newp)
 		}
 	}
 	log.Infof("%d points generated\n", len(r.points))
@@ -149,20 +180,20 @@ func (u *Universe) generatePoints() {
 			points = append(points, p)
 		}
 	}
-	u.Region.generatePoints(u.MinPlaceDist, points)
+	u.Region.generatePoints(u.MinPlaceDist, points, u.rnd)
 }
 
+// computeDists returns the length of every segment from a source point to
+// another point shorter than maxWayLength. Segments are normalized so that a
+// pair of points yields only one segment.
 func computeDists(srcs, dsts []point, maxWayLength float64) map[segment]float64 {
 	dists := make(map[segment]float64)
-	srcdsts := append(srcs, dsts...)
+	index := newGrid(maxWayLength)
+	index.addPoints(srcs...)
+	index.addPoints(dsts...)
 	for _, a := range srcs {
-		for _, b := range srcdsts {
-			if a.equal(b) {
-				continue
-			}
-			if d := dist(a, b); d <= maxWayLength {
-				dists[segment{a: a, b: b}] = d
-			}
+		for _, b := range index.neighbours(a, maxWayLength) {
+			dists[newSegment(a, b)] = dist(a, b)
 		}
 	}
 	log.Infof("%d potential segments\n", len(dists))
@@ -170,14 +201,33 @@ func computeDists(srcs, dsts []point, maxWayLength float64) map[segment]float64
 	return dists
 }
 
-func generateSegments(dists map[segment]float64, existingSegments []segment) []segment {
+// shuffledSegments returns the segments in a random order that only depends
+// on rnd.
+func shuffledSegments(dists map[segment]float64, rnd *rand.Rand) []segment {
+	segments := make([]segment, 0, len(dists))
+	for s := range dists {
+		segments = append(segments, s)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].less(segments[j]) })
+	rnd.Shuffle(len(segments), func(i, j int) { segments[i], segments[j] = segments[j], segments[i] })
+	return segments
+}
+
+func generateSegments(dists map[segment]float64, existingSegments []segment, rnd *rand.Rand) []segment {
 	segments := make([]segment, 0)
+	maxLength := 0.0
+	for _, d := range dists {
+		maxLength = math.Max(maxLength, d)
+	}
+	index := newGrid(maxLength)
+	index.addSegments(existingSegments...)
 
-	for news := range dists {
-		if news.intersect(existingSegments...) || news.intersect(segments...) {
+	for _, news := range shuffledSegments(dists, rnd) {
+		if index.intersect(news) {
 			continue
 		}
 		segments = append(segments, news)
+		index.addSegments(news)
 	}
 	log.Infof("generated %d segments\n", len(segments))
 
@@ -192,8 +242,7 @@ func (u *Universe) generateSegments() {
 		dests = append(dests, r.points...)
 		existingSegments = append(existingSegments, r.segments...)
 	}
-	dists := computeDists(sources, dests, u.MaxWayLength)
-	u.Region.segments = generateSegments(dists, existingSegments)
+	u.Region.segments = u.linkSegments(sources, dests, u.MaxWayLength, existingSegments)
 }
 
 func (u *Universe) generateRegions() {
@@ -201,7 +250,7 @@ func (u *Universe) generateRegions() {
 
 	for i := 0; i < u.RegionConfig.Count; i++ {
 		for {
-			p := randPointInRegion(u.Region)
+			p := randPointInRegion(u.Region, u.rnd)
 			ok := true
 			for _, r := range u.Regions {
 				if dist(r.Center, p) <= r.Radius*2 {
@@ -224,49 +273,103 @@ func (u *Universe) densifyRegions() {
 	segments := make([]segment, 0)
 	for _, r := range u.Regions {
 		log.Infof("region [%f, %f] r%f\n", r.Center.x, r.Center.y, r.Radius)
-		r.generatePoints(u.RegionConfig.MinPlaceDist, points)
-		dists := computeDists(r.points, points, u.RegionConfig.MaxWayLength)
-		r.segments = generateSegments(dists, segments)
+		r.generatePoints(u.RegionConfig.MinPlaceDist, points, u.rnd)
+		r.segments = u.linkSegments(r.points, points, u.RegionConfig.MaxWayLength, segments)
 	}
 }
 
-func placeFromPoint(p point, markovGen *markov.Chains, names map[string]bool) *model.Place {
-	var name string
-	for {
-		name = markovGen.Generate()
-		if _, found := names[name]; !found {
-			break
+// newName generates a name that is not already in names, and adds it.
+func newName(markovGen *markov.NameGenerator, names map[string]bool) (string, error) {
+	name, err := markovGen.Generate(names)
+	if err != nil {
+		return "", err
+	}
+	names[name] = true
+	return name, nil
+}
+
+func placeFromPoint(p point, markovGen *markov.NameGenerator, names map[string]bool) (*model.Place, error) {
+	name, err := newName(markovGen, names)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewPlace(name, int(p.x), int(p.y)), nil
+}
+
+// checkCorpora returns an error if a name corpus is not registered.
+func checkCorpora(corpora []string) error {
+	for _, c := range corpora {
+		if _, err := markov.Corpus(c); err != nil {
+			return err
 		}
 	}
-	if len(name) == 0 {
-		log.Fatal("markov random name is empty!")
+	return nil
+}
+
+// pickCorpus sets the name generator of a region, from one of the corpora
+// picked at random with the rules of the default generator, or the default
+// generator if there is none.
+func (u *Universe) pickCorpus(r *Region, corpora []string) error {
+	if len(corpora) == 0 {
+		r.nameGen = u.MarkovGen
+		return nil
+	}
+	r.Corpus = corpora[u.rnd.Intn(len(corpora))]
+	g, err := markov.NewCorpusNameGenerator(r.Corpus, u.MarkovGen.Rules())
+	if err != nil {
+		return err
 	}
-	names[name] = true
-	return model.NewPlace(name, int(p.x), int(p.y))
+	g.Seed(u.rnd.Int63())
+	r.nameGen = g
+	log.Infof("region [%f, %f] is named from corpus %s", r.Center.x, r.Center.y, r.Corpus)
+	return nil
 }
 
-func wormholeFromSegment(s segment, places []*model.Place) *model.Wormhole {
-	srcFound, dstFound := false, false
-	var src, dst *model.Place
-	for _, p := range places {
-		if p.X == int(s.a.x) && p.Y == int(s.a.y) {
-			src = p
-			srcFound = true
+// saveRegions names the regions and stores them in database.
+func (u *Universe) saveRegions(tx *sql.Tx) error {
+	for _, r := range u.Regions {
+		if err := u.pickCorpus(r, u.RegionConfig.Corpora); err != nil {
+			return err
 		}
-		if p.X == int(s.b.x) && p.Y == int(s.b.y) {
-			dst = p
-			dstFound = true
+		name, err := newName(r.nameGen, u.names)
+		if err != nil {
+			return err
 		}
-		if srcFound && dstFound {
-			break
+		r.Name = name
+		m := model.NewRegion(r.Name, int(r.Center.x), int(r.Center.y), int(r.Radius))
+		if err := m.Save(tx); err != nil {
+			return err
 		}
+		r.ID = m.ID
 	}
+	log.Infof("Saved %d regions to database\n", len(u.Regions))
+	return nil
+}
 
-	return model.NewWormhole(src, dst, int(dist(s.a, s.b)))
+// truncate returns the position of a place created at point p.
+func truncate(p point) point {
+	return point{x: float64(int(p.x)), y: float64(int(p.y))}
+}
+
+// placesByPoint indexes places by their position, to find the places at the
+// ends of segments.
+func placesByPoint(places []*model.Place) map[point]*model.Place {
+	index := make(map[point]*model.Place, len(places))
+	for _, p := range places {
+		index[placePoint(p)] = p
+	}
+	return index
+}
+
+func wormholeFromSegment(s segment, places map[point]*model.Place) *model.Wormhole {
+	return model.NewWormhole(places[truncate(s.a)], places[truncate(s.b)], int(dist(s.a, s.b)))
 }
 
 func (u *Universe) makePlacesAndWormholes(tx *sql.Tx) error {
 	u.names = make(map[string]bool)
+	if err := u.saveRegions(tx); err != nil {
+		return err
+	}
 
 	np := len(u.Region.points)
 	for _, r := range u.Regions {
@@ -274,16 +377,23 @@ func (u *Universe) makePlacesAndWormholes(tx *sql.Tx) error {
 	}
 	u.Places = make([]*model.Place, np)
 	n := 0
+	var err error
 	for _, p := range u.Region.points {
-		u.Places[n] = placeFromPoint(p, u.MarkovGen, u.names)
+		if u.Places[n], err = placeFromPoint(p, u.MarkovGen, u.names); err != nil {
+			return err
+		}
 		n++
 	}
 	for _, r := range u.Regions {
 		for _, p := range r.points {
-			u.Places[n] = placeFromPoint(p, u.MarkovGen, u.names)
+			if u.Places[n], err = placeFromPoint(p, r.nameGen, u.names); err != nil {
+				return err
+			}
+			u.Places[n].RegionID = r.ID
 			n++
 		}
 	}
+	u.assignEnergy()
 	for _, p := range u.Places {
 		if err := p.Save(tx); err != nil {
 			return err
@@ -296,23 +406,40 @@ func (u *Universe) makePlacesAndWormholes(tx *sql.Tx) error {
 		ns += len(r.segments)
 	}
 	u.Wormholes = make([]*model.Wormhole, ns)
+	places := placesByPoint(u.Places)
 	n = 0
 	for _, s := range u.Region.segments {
-		u.Wormholes[n] = wormholeFromSegment(s, u.Places)
+		u.Wormholes[n] = wormholeFromSegment(s, places)
 		n++
 	}
 	for _, r := range u.Regions {
 		for _, s := range r.segments {
-			u.Wormholes[n] = wormholeFromSegment(s, u.Places)
+			u.Wormholes[n] = wormholeFromSegment(s, places)
 			n++
 		}
 	}
+	u.addAnomalies()
 	for _, w := range u.Wormholes {
 		if err := w.Save(tx); err != nil {
 			return err
 		}
 	}
 
+	return u.saveGeneration(tx)
+}
+
+// saveGeneration records the seed and configuration used to generate the
+// universe.
+func (u *Universe) saveGeneration(tx *sql.Tx) error {
+	cfg, err := json.Marshal(u.Config)
+	if err != nil {
+		return err
+	}
+	g := model.NewUniverseGeneration(u.Seed, string(cfg))
+	if err := g.Save(tx); err != nil {
+		return err
+	}
+	log.Infof("Universe generated with seed %d", u.Seed)
 	return nil
 }
 
@@ -325,8 +452,30 @@ func (u *Universe) cleanup() {
 	}
 }
 
-// Generate generates a new random universe
-func Generate(cfg Config, tx *sql.Tx) *Universe {
+/*
+Generate generates a new random universe and saves it to the database.
+
+The graph of wormholes is guaranteed to be connected: if generated ways leave
+some places unreachable, the shortest non-crossing wormholes joining them to
+the rest of the universe are added. An error is returned if this is not
+possible, or if the database already holds a universe.
+*/
+func Generate(cfg Config, tx *sql.Tx) (*Universe, error) {
+	n, err := model.CountPlaces(tx)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, mwkerr.New(mwkerr.DatabaseAlreadyInitialized, "Universe already generated (%d places)", n)
+	}
+
+	if err := checkLinkStrategy(cfg.LinkStrategy); err != nil {
+		return nil, err
+	}
+	if err := checkCorpora(cfg.RegionConfig.Corpora); err != nil {
+		return nil, err
+	}
+
 	u := newUniverse(cfg)
 
 	log.Infof("Computing regions...")
@@ -338,11 +487,18 @@ func Generate(cfg Config, tx *sql.Tx) *Universe {
 	log.Infof("Computing ways...")
 	u.generateSegments()
 
+	log.Infof("Checking connectivity...")
+	report, err := u.connect()
+	u.Connectivity = report
+	if err != nil {
+		return u, err
+	}
+
 	if err := u.makePlacesAndWormholes(tx); err != nil {
-		log.Fatal(err)
+		return u, err
 	}
 
 	u.cleanup()
 
-	return u
+	return u, nil
 }